@@ -5,13 +5,22 @@ import (
 	"fmt"
 	"os"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
 
 	"github.com/rancher/lasso/pkg/controller"
+	"github.com/rancher/wrangler/pkg/generated/controllers/core"
 	"github.com/rancher/wrangler/pkg/generic"
 	"github.com/rancher/wrangler/pkg/kubeconfig"
+	"github.com/rancher/wrangler/pkg/leader"
 	"github.com/rancher/wrangler/pkg/schemes"
 	"github.com/rancher/wrangler/pkg/signals"
 	"github.com/rancher/wrangler/pkg/start"
@@ -19,10 +28,21 @@ import (
 	"github.com/urfave/cli/v2"
 
 	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/controller/deviceplugin"
+	"github.com/harvester/pcidevices/pkg/controller/devicereset"
+	"github.com/harvester/pcidevices/pkg/controller/orphanedclaim"
 	"github.com/harvester/pcidevices/pkg/controller/pcidevice"
 	"github.com/harvester/pcidevices/pkg/controller/pcideviceclaim"
+	"github.com/harvester/pcidevices/pkg/controller/permittedhostdevices"
+	"github.com/harvester/pcidevices/pkg/controller/sriovdevice"
 	"github.com/harvester/pcidevices/pkg/crd"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned"
 	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io"
+	"github.com/harvester/pcidevices/pkg/nodelabels"
+	"github.com/harvester/pcidevices/pkg/pciids"
+	"github.com/harvester/pcidevices/pkg/sysfs"
+	"github.com/harvester/pcidevices/pkg/topology"
+	"github.com/harvester/pcidevices/pkg/uevent"
 )
 
 const (
@@ -45,7 +65,7 @@ func init() {
 
 func main() {
 	// set up the kubeconfig and other args
-	var kubeConfig string
+	var opts options
 	app := cli.NewApp()
 	app.Name = controllerName
 	app.Version = VERSION
@@ -54,13 +74,78 @@ func main() {
 		&cli.StringFlag{
 			Name:        "kubeconfig",
 			EnvVars:     []string{"KUBECONFIG"},
-			Destination: &kubeConfig,
+			Destination: &opts.kubeConfig,
 			Usage:       "Kube config for accessing k8s cluster",
 		},
+		&cli.StringFlag{
+			Name:        "sysfs-root",
+			EnvVars:     []string{"SYSFS_ROOT"},
+			Value:       sysfs.DefaultRoot,
+			Destination: &opts.sysfsRoot,
+			Usage:       "Root of the sysfs tree used to discover PCI devices",
+		},
+		&cli.StringFlag{
+			Name:        "pci-ids",
+			EnvVars:     []string{"PCI_IDS"},
+			Destination: &opts.pciIDsPath,
+			Usage:       "Path to the pci.ids database used to name PCI devices, e.g. one mounted from the host. Defaults to the one bundled in the image.",
+		},
+		&cli.StringFlag{
+			Name:        "node-name",
+			EnvVars:     []string{"NODENAME"},
+			Destination: &opts.nodeName,
+			Usage:       "Name of the Kubernetes node the controller runs on. Defaults to the hostname.",
+		},
+		&cli.StringSliceFlag{
+			Name:        "node-labels",
+			EnvVars:     []string{"NODE_LABELS"},
+			Value:       cli.NewStringSlice("gpu"),
+			Destination: &opts.nodeLabels,
+			Usage:       "Labels to publish on the node: gpu, class:<class>, e.g. class:0302, or device:<vendor>:<device>, e.g. device:10de:1eb8. Use class:* or device:* for every class or device on the node.",
+		},
+		&cli.StringFlag{
+			Name:        "device-plugin-dir",
+			EnvVars:     []string{"DEVICE_PLUGIN_DIR"},
+			Value:       pluginapi.DevicePluginPath,
+			Destination: &opts.devicePluginDir,
+			Usage:       "Directory of the kubelet device plugin sockets, which the claimed devices are advertised through",
+		},
+		&cli.BoolFlag{
+			Name:        "kubevirt-permitted-host-devices",
+			EnvVars:     []string{"KUBEVIRT_PERMITTED_HOST_DEVICES"},
+			Destination: &opts.kubeVirtPermittedHostDevices,
+			Usage:       "Keep the permitted PCI host devices in the KubeVirt CR in sync with the models of the claimed devices",
+		},
+		&cli.StringFlag{
+			Name:        "namespace",
+			EnvVars:     []string{"NAMESPACE"},
+			Value:       "harvester-system",
+			Destination: &opts.namespace,
+			Usage:       "Namespace of the leader election lock of the cluster-wide controllers",
+		},
 	}
 
 	app.Action = func(c *cli.Context) error {
-		return run(kubeConfig)
+		return run(opts)
+	}
+
+	var topologyNode string
+	app.Commands = []*cli.Command{
+		{
+			Name:  "topology",
+			Usage: "Print the PCI bus tree of a node, from its PCIDevices",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:        "node",
+					Required:    true,
+					Destination: &topologyNode,
+					Usage:       "Name of the node",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return printTopology(opts.kubeConfig, topologyNode)
+			},
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -68,14 +153,55 @@ func main() {
 	}
 }
 
-func run(kubeConfig string) error {
+type options struct {
+	kubeConfig string
+	sysfsRoot  string
+	pciIDsPath string
+	nodeName   string
+	nodeLabels cli.StringSlice
+	namespace  string
+
+	devicePluginDir              string
+	kubeVirtPermittedHostDevices bool
+}
+
+func run(opts options) error {
 	ctx := signals.SetupSignalContext()
 
+	nodeName := opts.nodeName
+	if nodeName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		logrus.Warnf("Node name not set, using the hostname %s, which may differ from the Kubernetes node name", hostname)
+		nodeName = hostname
+	}
+
+	nodeLabels, err := nodelabels.ParseConfig(opts.nodeLabels.Value())
+	if err != nil {
+		return err
+	}
+
 	var cfg *rest.Config
-	cfg, err := kubeconfig.GetNonInteractiveClientConfig(kubeConfig).ClientConfig()
+	cfg, err = kubeconfig.GetNonInteractiveClientConfig(opts.kubeConfig).ClientConfig()
 	if err != nil {
 		return fmt.Errorf("failed to find kubeconfig: %v", err)
 	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Record events on the PCIDevices and PCIDeviceClaims, so that they show up in kubectl describe
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	defer broadcaster.Shutdown()
+	recorder := broadcaster.NewRecorder(Scheme, corev1.EventSource{Component: controllerName, Host: nodeName})
 
 	// Create CRDs
 	err = crd.Create(ctx, cfg)
@@ -88,43 +214,95 @@ func run(kubeConfig string) error {
 	if err != nil {
 		return err
 	}
-	opts := &generic.FactoryOptions{
+	factoryOpts := &generic.FactoryOptions{
 		SharedControllerFactory: factory,
 	}
-	pdfactory, err := ctl.NewFactoryFromConfigWithOptions(cfg, opts)
+	pdfactory, err := ctl.NewFactoryFromConfigWithOptions(cfg, factoryOpts)
 	if err != nil {
 		return fmt.Errorf("error building pcidevice controllers: %s", err.Error())
 	}
-	pdcfactory, err := ctl.NewFactoryFromConfigWithOptions(cfg, opts)
+	pdcfactory, err := ctl.NewFactoryFromConfigWithOptions(cfg, factoryOpts)
 	if err != nil {
 		return fmt.Errorf("error building pcideviceclaim controllers: %s", err.Error())
 	}
+	corefactory, err := core.NewFactoryFromConfigWithOptions(cfg, factoryOpts)
+	if err != nil {
+		return fmt.Errorf("error building core controllers: %s", err.Error())
+	}
 	if err != nil {
 		return err
 	}
+	pciIDsPaths := pciids.DefaultPaths
+	if opts.pciIDsPath != "" {
+		pciIDsPaths = []string{opts.pciIDsPath}
+	}
+	ids, err := pciids.Load(pciIDsPaths...)
+	if err != nil {
+		logrus.Warnf("Failed to load the pci.ids database, falling back to built-in PCI device names: %v", err)
+	}
+
 	registerControllers := func(ctx context.Context) {
 		pdCtl := pdfactory.Devices().V1beta1().PCIDevice()
+		pdcCtl := pdcfactory.Devices().V1beta1().PCIDeviceClaim()
+		sdCtl := pdfactory.Devices().V1beta1().SRIOVDevice()
+		nodeCtl := corefactory.Core().V1().Node()
+		fs := sysfs.New(opts.sysfsRoot, "")
 		logrus.Info("Starting PCI Devices controller")
-		if err := pcidevice.Register(ctx, pdCtl); err != nil {
+		if err := pcidevice.Register(ctx, pdCtl, pdcCtl, nodeCtl, fs, ids, uevent.NewNetlinkSource(), nodeName, nodeLabels, recorder); err != nil {
 			logrus.Fatalf("failed to register PCI Devices Controller")
 		}
 
-		pdcCtl := pdcfactory.Devices().V1beta1().PCIDeviceClaim()
 		logrus.Info("Starting PCI Device Claims Controller")
-		if err = pcideviceclaim.Register(ctx, pdcCtl, pdCtl); err != nil {
+		if err = pcideviceclaim.Register(ctx, pdcCtl, pdCtl, fs, nodeName, recorder); err != nil {
 			logrus.Fatalf("failed to register PCI Device Claims Controller")
 		}
+
+		logrus.Info("Starting PCI Device Reset Controller")
+		devicereset.Register(ctx, pdCtl, fs, nodeName)
+
+		logrus.Info("Starting Device Plugins Controller")
+		deviceplugin.Register(ctx, pdCtl, pdcCtl, opts.devicePluginDir, nodeName)
+
+		logrus.Info("Starting SR-IOV Devices Controller")
+		if err := sriovdevice.Register(ctx, sdCtl, pdcCtl, fs, nodeName); err != nil {
+			logrus.Fatalf("failed to register SR-IOV Devices Controller")
+		}
 	}
 
 	startAllControllers := func(ctx context.Context) {
-		if err := start.All(ctx, 2, pdfactory, pdcfactory); err != nil {
+		if err := start.All(ctx, 2, pdfactory, pdcfactory, corefactory); err != nil {
 			logrus.Fatalf("Error starting: %s", err.Error())
 		}
 	}
 
 	registerControllers(ctx)
 	startAllControllers(ctx)
+
+	// The cluster-wide controllers only run on the elected leader
+	go leader.RunOrDie(ctx, opts.namespace, "pcidevices-cluster-controllers", kubeClient, func(ctx context.Context) {
+		orphanedclaim.Register(ctx, pdcfactory.Devices().V1beta1().PCIDeviceClaim(), corefactory.Core().V1().Node())
+		if opts.kubeVirtPermittedHostDevices {
+			permittedhostdevices.Register(ctx, pdfactory.Devices().V1beta1().PCIDevice(), pdcfactory.Devices().V1beta1().PCIDeviceClaim(), dynamicClient)
+		}
+		startAllControllers(ctx)
+	})
 	<-ctx.Done()
 
 	return nil
 }
+
+func printTopology(kubeConfig string, nodeName string) error {
+	cfg, err := kubeconfig.GetNonInteractiveClientConfig(kubeConfig).ClientConfig()
+	if err != nil {
+		return fmt.Errorf("failed to find kubeconfig: %v", err)
+	}
+	client, err := versioned.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	pds, err := client.DevicesV1beta1().PCIDevices().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	return topology.Print(os.Stdout, pds.Items, nodeName)
+}
@@ -0,0 +1,80 @@
+package v1beta1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PCIAddress is the address of a PCI function: its segment (domain), bus, device and function.
+// Its canonical form is DDDD:BB:DD.F in lowercase hex, e.g. 0000:3b:00.1, which is how the
+// kernel names devices in sysfs. Domains above ffff exist, e.g. behind an Intel VMD controller.
+type PCIAddress struct {
+	Domain   uint32
+	Bus      uint8
+	Device   uint8
+	Function uint8
+}
+
+// ParsePCIAddress parses and validates an address in DDDD:BB:DD.F form. The domain may be
+// left out, as lspci does for domain 0, so BB:DD.F is accepted too.
+func ParsePCIAddress(addr string) (PCIAddress, error) {
+	var a PCIAddress
+	parts := strings.Split(strings.TrimSpace(addr), ":")
+	switch len(parts) {
+	case 2:
+		parts = append([]string{"0"}, parts...)
+	case 3:
+	default:
+		return a, fmt.Errorf("invalid PCI address %q: expected DDDD:BB:DD.F", addr)
+	}
+	device, function, found := strings.Cut(parts[2], ".")
+	if !found {
+		return a, fmt.Errorf("invalid PCI address %q: expected DDDD:BB:DD.F", addr)
+	}
+	domain, err := parseHex(parts[0], 8, 32)
+	if err != nil {
+		return a, fmt.Errorf("invalid domain in PCI address %q: %w", addr, err)
+	}
+	bus, err := parseHex(parts[1], 2, 8)
+	if err != nil {
+		return a, fmt.Errorf("invalid bus in PCI address %q: %w", addr, err)
+	}
+	dev, err := parseHex(device, 2, 5)
+	if err != nil {
+		return a, fmt.Errorf("invalid device in PCI address %q: %w", addr, err)
+	}
+	fn, err := parseHex(function, 1, 3)
+	if err != nil {
+		return a, fmt.Errorf("invalid function in PCI address %q: %w", addr, err)
+	}
+	a.Domain, a.Bus, a.Device, a.Function = uint32(domain), uint8(bus), uint8(dev), uint8(fn)
+	return a, nil
+}
+
+// parseHex parses a hex number of at most maxDigits digits, which must fit in bits
+func parseHex(s string, maxDigits int, bits int) (uint64, error) {
+	if s == "" || len(s) > maxDigits {
+		return 0, fmt.Errorf("expected 1 to %d hex digits, got %q", maxDigits, s)
+	}
+	return strconv.ParseUint(s, 16, bits)
+}
+
+// CanonicalPCIAddress returns the canonical form of addr, or addr itself if it is not a valid address
+func CanonicalPCIAddress(addr string) string {
+	a, err := ParsePCIAddress(addr)
+	if err != nil {
+		return addr
+	}
+	return a.String()
+}
+
+func (a PCIAddress) String() string {
+	return fmt.Sprintf("%04x:%02x:%02x.%x", a.Domain, a.Bus, a.Device, a.Function)
+}
+
+// DNSLabel returns the address in a form that can be used in object names, e.g. 0000-3b-00-1.
+// Unlike stripping the separators, it can't collide for different domains.
+func (a PCIAddress) DNSLabel() string {
+	return fmt.Sprintf("%04x-%02x-%02x-%x", a.Domain, a.Bus, a.Device, a.Function)
+}
@@ -0,0 +1,37 @@
+package v1beta1
+
+import "testing"
+
+func TestParsePCIAddress(t *testing.T) {
+	tests := []struct {
+		addr      string
+		canonical string
+		dnsLabel  string
+	}{
+		{addr: "0000:3b:00.1", canonical: "0000:3b:00.1", dnsLabel: "0000-3b-00-1"},
+		{addr: "0000:3B:00.1", canonical: "0000:3b:00.1", dnsLabel: "0000-3b-00-1"},
+		{addr: "00:1f.6", canonical: "0000:00:1f.6", dnsLabel: "0000-00-1f-6"},
+		{addr: "0001:00:10.0", canonical: "0001:00:10.0", dnsLabel: "0001-00-10-0"},
+		{addr: "10000:e1:00.0", canonical: "10000:e1:00.0", dnsLabel: "10000-e1-00-0"},
+		{addr: "1:2:3.4", canonical: "0001:02:03.4", dnsLabel: "0001-02-03-4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			a, err := ParsePCIAddress(tt.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.String() != tt.canonical || a.DNSLabel() != tt.dnsLabel {
+				t.Errorf("expected %s and %s, got %s and %s", tt.canonical, tt.dnsLabel, a.String(), a.DNSLabel())
+			}
+		})
+	}
+}
+
+func TestParseInvalidPCIAddress(t *testing.T) {
+	for _, addr := range []string{"", "0000:3b:00", "0000:3b:20.0", "0000:3b:00.8", "0000:100:00.0", "00:00:3b:00.0", "0000:3b:0g.0", "0000:3b:00.-1"} {
+		if a, err := ParsePCIAddress(addr); err == nil {
+			t.Errorf("expected %q to be invalid, got %s", addr, a)
+		}
+	}
+}
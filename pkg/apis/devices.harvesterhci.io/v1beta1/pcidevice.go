@@ -2,14 +2,43 @@ package v1beta1
 
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"strings"
 
-	"github.com/harvester/pcidevices/pkg/lspci"
+	"github.com/harvester/pcidevices/pkg/pciids"
+	"github.com/harvester/pcidevices/pkg/sysfs"
 	"github.com/sirupsen/logrus"
 	"github.com/u-root/u-root/pkg/pci"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// NUMANodeLabel is set on a PCIDevice to the NUMA node the device is attached to
+	NUMANodeLabel = "pcidevices.harvesterhci.io/numa-node"
+	// ClassLabel is set on a PCIDevice to its class and subclass code in hex, e.g. "0300" for a VGA controller
+	ClassLabel = "pcidevices.harvesterhci.io/class"
+	// SubsystemVendorIdLabel and SubsystemDeviceIdLabel are set to the subsystem IDs in hex, e.g. "1028"
+	SubsystemVendorIdLabel = "pcidevices.harvesterhci.io/subsystem-vendor-id"
+	SubsystemDeviceIdLabel = "pcidevices.harvesterhci.io/subsystem-device-id"
+)
+
+// derivedLabelKeys are the labels that UpdateLabels manages
+var derivedLabelKeys = []string{NUMANodeLabel, ClassLabel, SubsystemVendorIdLabel, SubsystemDeviceIdLabel}
+
+const (
+	// PCIDeviceLinkDegraded is the condition set on a PCI Express device, which is true when
+	// its link trained at a lower speed or width than the maximum the link supports
+	PCIDeviceLinkDegraded = "LinkDegraded"
+	// PCIDeviceAbsent is the condition set on a PCIDevice that is no longer on the bus,
+	// but is kept around because a PCIDeviceClaim still references it
+	PCIDeviceAbsent = "Absent"
+	// PCIDeviceHealthy is the condition set on a device with Advanced Error Reporting, which is false
+	// once its fatal or non-fatal error count increased. Devices that aren't healthy aren't handed out.
+	PCIDeviceHealthy = "Healthy"
+)
+
 // +genclient
 // +genclient:nonNamespaced
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -26,51 +55,524 @@ type PCIDevice struct {
 
 // PCIDeviceStatus defines the observed state of PCIDevice
 type PCIDeviceStatus struct {
-	Address           string   `json:"address"`
-	VendorId          int      `json:"vendorId"`
-	DeviceId          int      `json:"deviceId"`
-	NodeName          string   `json:"nodeName"`
-	Description       string   `json:"description"`
+	Address     string `json:"address"`
+	VendorId    int    `json:"vendorId"`
+	DeviceId    int    `json:"deviceId"`
+	NodeName    string `json:"nodeName"`
+	Description string `json:"description"`
+	// VendorName and DeviceName are resolved from the pci.ids database, like lspci does
+	VendorName        string   `json:"vendorName,omitempty"`
+	DeviceName        string   `json:"deviceName,omitempty"`
+	SubsystemName     string   `json:"subsystemName,omitempty"`
 	KernelDriverInUse string   `json:"kernelDriverInUse,omitempty"`
 	KernelModules     []string `json:"kernelModules"`
+	ClassId           int      `json:"classId"`
+	SubclassId        int      `json:"subclassId"`
+	ProgIfId          int      `json:"progIfId"`
+	ClassName         string   `json:"className,omitempty"`
+	SubclassName      string   `json:"subclassName,omitempty"`
+	ProgIfName        string   `json:"progIfName,omitempty"`
+	SubsystemVendorId int      `json:"subsystemVendorId"`
+	SubsystemDeviceId int      `json:"subsystemDeviceId"`
+	Revision          int      `json:"revision"`
+	IOMMUGroup        string   `json:"iommuGroup,omitempty"`
+	// IOMMUGroupDevices are the addresses of the other devices in the same IOMMU group,
+	// which have to be passed through together with this one
+	IOMMUGroupDevices []string `json:"iommuGroupDevices,omitempty"`
+	// UpstreamBridges are the addresses of the bridges between the device and the root complex,
+	// starting with the root port. ParentAddress is the bridge the device is directly behind.
+	UpstreamBridges []string `json:"upstreamBridges,omitempty"`
+	ParentAddress   string   `json:"parentAddress,omitempty"`
+	RootPort        string   `json:"rootPort,omitempty"`
+	// NUMANode is the NUMA node the device is attached to, if the platform reports one
+	NUMANode *int `json:"numaNode,omitempty"`
+	// LocalCPUList are the CPUs on the same NUMA node as the device, e.g. "0-15,32-47"
+	LocalCPUList string `json:"localCPUList,omitempty"`
+	// Regions are the BARs of the device that decode I/O or memory space
+	Regions []PCIRegion `json:"regions,omitempty"`
+	// ExpansionROM is only set if the device has an option ROM
+	ExpansionROM *PCIRegion `json:"expansionROM,omitempty"`
+	// Link is only set for PCI Express devices
+	Link *PCIeLinkStatus `json:"link,omitempty"`
+	// SRIOV is only set for SR-IOV physical and virtual functions
+	SRIOV *SRIOVStatus `json:"sriov,omitempty"`
+	// ResetMethods are the methods the kernel can reset the device with, in the order it tries them.
+	// It's empty if the device can't be reset, or the kernel is older than 5.15.
+	ResetMethods []string `json:"resetMethods,omitempty"`
+	// LastReset is the outcome of the last reset requested through spec.resetRequestedAt
+	LastReset *PCIDeviceResetStatus `json:"lastReset,omitempty"`
+	// AER is only set for PCI Express devices with Advanced Error Reporting
+	AER *AERCounters `json:"aer,omitempty"`
+	// ResourceName is the extended resource the device is advertised as to the scheduler
+	// once it is claimed, e.g. pcidevices.harvesterhci.io/10de-1eb8
+	ResourceName string `json:"resourceName,omitempty"`
+
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
-func (status *PCIDeviceStatus) Update(dev *pci.PCI, hostname string) {
-	lspciOutput, err := lspci.GetLspciOuptut(dev.Addr)
-	if err != nil {
-		logrus.Error(err)
-	}
-	driver, err := lspci.ExtractCurrentPCIDriver(lspciOutput)
-	if err != nil {
+// Update refreshes the status from sysfs. Names are looked up in ids, falling back to
+// the names u-root knows if ids is nil or doesn't know the device.
+func (status *PCIDeviceStatus) Update(dev *pci.PCI, nodeName string, fs *sysfs.SysFS, ids *pciids.DB) {
+	driver, err := fs.Driver(dev.Addr)
+	if err != nil && err != sysfs.ErrNoDriver {
 		logrus.Error(err)
 		// Continue and update the object even if driver is not found
 	}
-	status.Address = dev.Addr
+	status.Address = CanonicalPCIAddress(dev.Addr)
 	status.VendorId = int(dev.Vendor)
 	status.DeviceId = int(dev.Device)
-	status.Description = dev.DeviceName
+	status.ResourceName = ResourceName(dev.Vendor, dev.Device)
 	status.KernelDriverInUse = driver
-	status.NodeName = hostname
+	status.NodeName = nodeName
 
-	modules, err := lspci.ExtractKernelModules(lspciOutput)
+	modules, err := fs.KernelModules(dev.Addr)
 	if err != nil {
 		logrus.Error(err)
 		// Continue and update the object even if modules are not found
 	}
 	status.KernelModules = modules
+
+	class := dev.Class
+	status.ClassId = int(class >> 16)
+	status.SubclassId = int(class>>8) & 0xff
+	status.ProgIfId = int(class) & 0xff
+	status.ClassName, status.SubclassName, status.ProgIfName = pciids.ClassNames(class)
+	subsystemVendor, err := fs.SubsystemVendor(dev.Addr)
+	if err != nil {
+		logrus.Error(err)
+	}
+	status.SubsystemVendorId = int(subsystemVendor)
+	subsystemDevice, err := fs.SubsystemDevice(dev.Addr)
+	if err != nil {
+		logrus.Error(err)
+	}
+	status.SubsystemDeviceId = int(subsystemDevice)
+	revision, err := fs.Revision(dev.Addr)
+	if err != nil {
+		logrus.Error(err)
+	}
+	status.Revision = int(revision)
+
+	status.VendorName = ids.VendorName(dev.Vendor)
+	status.DeviceName = ids.DeviceName(dev.Vendor, dev.Device)
+	if status.VendorName == "" || status.DeviceName == "" {
+		vendorName, deviceName := uRootNames(dev)
+		if status.VendorName == "" {
+			status.VendorName = vendorName
+		}
+		if status.DeviceName == "" {
+			status.DeviceName = deviceName
+		}
+	}
+	status.SubsystemName = ids.SubsystemName(dev.Vendor, dev.Device, subsystemVendor, subsystemDevice)
+	status.Description = description(status.SubclassName, status.VendorName, status.DeviceName)
+
+	status.IOMMUGroup, status.IOMMUGroupDevices = "", nil
+	group, err := fs.IOMMUGroup(dev.Addr)
+	if err != nil && err != sysfs.ErrNoIOMMUGroup {
+		logrus.Error(err)
+	}
+	if group != "" {
+		status.IOMMUGroup = group
+		groupDevices, err := fs.IOMMUGroupDevices(group)
+		if err != nil {
+			logrus.Error(err)
+		}
+		for _, addr := range groupDevices {
+			if addr != dev.Addr {
+				status.IOMMUGroupDevices = append(status.IOMMUGroupDevices, addr)
+			}
+		}
+	}
+
+	status.UpstreamBridges, status.ParentAddress, status.RootPort = nil, "", ""
+	bridges, err := fs.UpstreamBridges(dev.Addr)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if len(bridges) > 0 {
+		status.UpstreamBridges = bridges
+		status.RootPort = bridges[0]
+		status.ParentAddress = bridges[len(bridges)-1]
+	}
+
+	status.NUMANode = nil
+	numaNode, err := fs.NUMANode(dev.Addr)
+	if err != nil {
+		logrus.Error(err)
+	}
+	if numaNode >= 0 {
+		status.NUMANode = &numaNode
+	}
+	status.LocalCPUList, err = fs.LocalCPUList(dev.Addr)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.Error(err)
+	}
+
+	status.Regions, status.ExpansionROM = nil, nil
+	bars, rom, err := fs.Resources(dev.Addr)
+	if err != nil {
+		logrus.Error(err)
+	}
+	for _, bar := range bars {
+		status.Regions = append(status.Regions, newPCIRegion(bar))
+	}
+	if rom != nil {
+		region := newPCIRegion(*rom)
+		status.ExpansionROM = &region
+	}
+
+	link, err := newPCIeLinkStatus(dev.Addr, fs)
+	if err != nil {
+		logrus.Error(err)
+	}
+	status.Link = link
+	if link != nil {
+		meta.SetStatusCondition(&status.Conditions, link.DegradedCondition())
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, PCIDeviceLinkDegraded)
+	}
+
+	status.ResetMethods, err = fs.ResetMethods(dev.Addr)
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	aer, err := newAERCounters(dev.Addr, fs)
+	if err != nil {
+		logrus.Error(err)
+	}
+	status.updateHealth(aer)
+
+	sriov, err := newSRIOVStatus(dev.Addr, fs)
+	if err != nil {
+		logrus.Error(err)
+	}
+	status.SRIOV = sriov
+
+	// The device is on the bus, so it's no longer absent
+	meta.RemoveStatusCondition(&status.Conditions, PCIDeviceAbsent)
+}
+
+// ResourceName is the extended resource that devices with the vendor and device IDs are advertised as
+func ResourceName(vendorId uint16, deviceId uint16) string {
+	return fmt.Sprintf("pcidevices.harvesterhci.io/%04x-%04x", vendorId, deviceId)
+}
+
+// description formats the device like lspci does, e.g.
+// "Ethernet controller: Intel Corporation 82599 10 Gigabit Network Connection"
+func description(subclassName string, vendorName string, deviceName string) string {
+	if subclassName == "" {
+		return fmt.Sprintf("%s %s", vendorName, deviceName)
+	}
+	return fmt.Sprintf("%s: %s %s", subclassName, vendorName, deviceName)
+}
+
+const (
+	PCIRegionMemory = "memory"
+	PCIRegionIO     = "io"
+)
+
+// PCIRegion is a region of I/O or memory space decoded by a BAR or the expansion ROM
+type PCIRegion struct {
+	// BAR is the number of the BAR, or 6 for the expansion ROM
+	BAR  int    `json:"bar"`
+	Type string `json:"type"`
+	// Size is in bytes. Large 64-bit BARs, like the ones of Resizable BAR GPUs, need
+	// the VM firmware to reserve a big enough MMIO window above 4G.
+	Size         int64 `json:"size"`
+	Is64Bit      bool  `json:"is64Bit,omitempty"`
+	Prefetchable bool  `json:"prefetchable,omitempty"`
+}
+
+func newPCIRegion(r sysfs.Resource) PCIRegion {
+	region := PCIRegion{
+		BAR:          r.Index,
+		Type:         PCIRegionMemory,
+		Size:         int64(r.Size()),
+		Is64Bit:      r.Is64Bit(),
+		Prefetchable: r.IsPrefetchable(),
+	}
+	if r.IsIO() {
+		region.Type = PCIRegionIO
+	}
+	return region
+}
+
+// PCIeLinkStatus is the negotiated and maximum speed and width of a PCI Express link
+type PCIeLinkStatus struct {
+	CurrentSpeed string `json:"currentSpeed"`
+	CurrentWidth int    `json:"currentWidth"`
+	MaxSpeed     string `json:"maxSpeed"`
+	MaxWidth     int    `json:"maxWidth"`
+}
+
+func newPCIeLinkStatus(addr string, fs *sysfs.SysFS) (*PCIeLinkStatus, error) {
+	var link PCIeLinkStatus
+	var err error
+	if link.CurrentSpeed, err = fs.CurrentLinkSpeed(addr); err != nil {
+		if os.IsNotExist(err) {
+			// Not a PCI Express device
+			return nil, nil
+		}
+		return nil, err
+	}
+	if link.MaxSpeed, err = fs.MaxLinkSpeed(addr); err != nil {
+		return nil, err
+	}
+	if link.CurrentWidth, err = fs.CurrentLinkWidth(addr); err != nil {
+		return nil, err
+	}
+	if link.MaxWidth, err = fs.MaxLinkWidth(addr); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// linkSpeed parses a link speed like "8.0 GT/s PCIe" into GT/s. Unknown speeds are 0.
+func linkSpeed(speed string) float64 {
+	fields := strings.Fields(speed)
+	if len(fields) == 0 {
+		return 0
+	}
+	gts, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return gts
+}
+
+// DegradedCondition returns the LinkDegraded condition for the link. Note that some devices,
+// like GPUs, lower their link speed when idle, so only a lower width is a sure sign of trouble.
+func (l *PCIeLinkStatus) DegradedCondition() metav1.Condition {
+	condition := metav1.Condition{
+		Type:    PCIDeviceLinkDegraded,
+		Status:  metav1.ConditionFalse,
+		Reason:  "LinkAtMaximum",
+		Message: fmt.Sprintf("link trained at %s x%d", l.CurrentSpeed, l.CurrentWidth),
+	}
+	currentSpeed, maxSpeed := linkSpeed(l.CurrentSpeed), linkSpeed(l.MaxSpeed)
+	switch {
+	case l.CurrentWidth > 0 && l.CurrentWidth < l.MaxWidth:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "WidthDegraded"
+	case currentSpeed > 0 && currentSpeed < maxSpeed:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "SpeedDegraded"
+	}
+	if condition.Status == metav1.ConditionTrue {
+		condition.Message = fmt.Sprintf(
+			"link trained at %s x%d, below the maximum of %s x%d",
+			l.CurrentSpeed, l.CurrentWidth, l.MaxSpeed, l.MaxWidth,
+		)
+	}
+	return condition
+}
+
+// AERCounters are the numbers of errors the device reported through Advanced Error Reporting,
+// since it was enumerated
+type AERCounters struct {
+	Correctable int64 `json:"correctable"`
+	NonFatal    int64 `json:"nonFatal"`
+	Fatal       int64 `json:"fatal"`
+}
+
+func newAERCounters(addr string, fs *sysfs.SysFS) (*AERCounters, error) {
+	var aer AERCounters
+	var err error
+	if aer.Correctable, err = fs.AERTotal(addr, sysfs.AERCorrectable); err != nil {
+		if os.IsNotExist(err) {
+			// No AER capability, or the kernel doesn't have AER enabled
+			return nil, nil
+		}
+		return nil, err
+	}
+	if aer.NonFatal, err = fs.AERTotal(addr, sysfs.AERNonFatal); err != nil {
+		return nil, err
+	}
+	if aer.Fatal, err = fs.AERTotal(addr, sysfs.AERFatal); err != nil {
+		return nil, err
+	}
+	return &aer, nil
+}
+
+// updateHealth stores the AER counters, and sets the Healthy condition to false if the device reported
+// fatal or non-fatal errors since the previous counters. The condition stays false until the counters
+// are reset, e.g. when the node reboots, or until the condition is removed by hand.
+func (status *PCIDeviceStatus) updateHealth(aer *AERCounters) {
+	previous := status.AER
+	status.AER = aer
+	if aer == nil {
+		meta.RemoveStatusCondition(&status.Conditions, PCIDeviceHealthy)
+		return
+	}
+	reset := previous != nil && (aer.Fatal < previous.Fatal || aer.NonFatal < previous.NonFatal)
+	current := meta.FindStatusCondition(status.Conditions, PCIDeviceHealthy)
+	switch {
+	case previous != nil && !reset && (aer.Fatal > previous.Fatal || aer.NonFatal > previous.NonFatal):
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:   PCIDeviceHealthy,
+			Status: metav1.ConditionFalse,
+			Reason: "UncorrectableErrors",
+			Message: fmt.Sprintf("the device reported %d fatal and %d non-fatal errors",
+				aer.Fatal-previous.Fatal, aer.NonFatal-previous.NonFatal),
+		})
+	case current == nil || current.Status != metav1.ConditionFalse || reset:
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:    PCIDeviceHealthy,
+			Status:  metav1.ConditionTrue,
+			Reason:  "NoUncorrectableErrors",
+			Message: fmt.Sprintf("%d correctable errors", aer.Correctable),
+		})
+	}
+}
+
+// IsHealthy is false if the device reported uncorrectable errors
+func (d *PCIDevice) IsHealthy() bool {
+	return !meta.IsStatusConditionFalse(d.Status.Conditions, PCIDeviceHealthy)
+}
+
+// SRIOVStatus describes where a PCI function sits in an SR-IOV topology
+type SRIOVStatus struct {
+	// TotalVFs and NumVFs are the supported and enabled number of VFs of a PF
+	TotalVFs int `json:"totalVFs,omitempty"`
+	NumVFs   int `json:"numVFs,omitempty"`
+	// PhysFn is the address of the PF of a VF
+	PhysFn string `json:"physFn,omitempty"`
+	// VirtFns are the addresses of the VFs of a PF
+	VirtFns []string `json:"virtFns,omitempty"`
+}
+
+func (s *SRIOVStatus) IsPF() bool {
+	return s != nil && s.TotalVFs > 0
+}
+
+func (s *SRIOVStatus) IsVF() bool {
+	return s != nil && s.PhysFn != ""
+}
+
+func newSRIOVStatus(addr string, fs *sysfs.SysFS) (*SRIOVStatus, error) {
+	var sriov SRIOVStatus
+	var err error
+	if sriov.TotalVFs, err = fs.SRIOVTotalVFs(addr); err != nil {
+		return nil, err
+	}
+	if sriov.NumVFs, err = fs.SRIOVNumVFs(addr); err != nil {
+		return nil, err
+	}
+	if sriov.PhysFn, err = fs.PhysFn(addr); err != nil {
+		return nil, err
+	}
+	if sriov.VirtFns, err = fs.VirtFns(addr); err != nil {
+		return nil, err
+	}
+	if !sriov.IsPF() && !sriov.IsVF() {
+		return nil, nil
+	}
+	return &sriov, nil
+}
+
+// UpdateLabels sets the labels that are derived from the status, so that devices can
+// be selected by them. It returns true if the labels changed.
+func (d *PCIDevice) UpdateLabels() bool {
+	labels := map[string]string{
+		ClassLabel:             fmt.Sprintf("%02x%02x", d.Status.ClassId, d.Status.SubclassId),
+		SubsystemVendorIdLabel: fmt.Sprintf("%04x", d.Status.SubsystemVendorId),
+		SubsystemDeviceIdLabel: fmt.Sprintf("%04x", d.Status.SubsystemDeviceId),
+	}
+	if d.Status.NUMANode != nil {
+		labels[NUMANodeLabel] = strconv.Itoa(*d.Status.NUMANode)
+	}
+	changed := false
+	for _, key := range derivedLabelKeys {
+		value, found := labels[key]
+		if !found {
+			if _, exists := d.Labels[key]; exists {
+				delete(d.Labels, key)
+				changed = true
+			}
+			continue
+		}
+		if d.Labels[key] != value {
+			if d.Labels == nil {
+				d.Labels = map[string]string{}
+			}
+			d.Labels[key] = value
+			changed = true
+		}
+	}
+	return changed
 }
 
 type PCIDeviceSpec struct {
+	// ResetRequestedAt requests a reset of the device. The agent resets the device once for every
+	// new timestamp, if the device is unbound, or bound to vfio-pci and not in use by a VM.
+	ResetRequestedAt *metav1.Time `json:"resetRequestedAt,omitempty"`
+	// ResetMethod is one of status.resetMethods, e.g. "flr" for a function-level reset or "bus"
+	// for a secondary bus reset. If it's empty, the kernel tries the reset methods in order.
+	ResetMethod string `json:"resetMethod,omitempty"`
 }
 
-func PCIDeviceNameForHostname(dev *pci.PCI, hostname string) string {
-	vendorName := strings.ToLower(
-		strings.Split(dev.VendorName, " ")[0],
-	)
+// ResetPending is true if a reset was requested that wasn't carried out yet
+func (d *PCIDevice) ResetPending() bool {
+	requestedAt := d.Spec.ResetRequestedAt
+	return requestedAt != nil && (d.Status.LastReset == nil || !d.Status.LastReset.RequestedAt.Equal(requestedAt))
+}
+
+// Results of a reset
+const (
+	PCIDeviceResetSucceeded = "Succeeded"
+	PCIDeviceResetFailed    = "Failed"
+	// PCIDeviceResetRefused means the device wasn't reset because it was in use
+	PCIDeviceResetRefused = "Refused"
+)
+
+// PCIDeviceResetStatus is the outcome of the reset requested at RequestedAt
+type PCIDeviceResetStatus struct {
+	RequestedAt metav1.Time `json:"requestedAt"`
+	Time        metav1.Time `json:"time"`
+	Method      string      `json:"method,omitempty"`
+	Result      string      `json:"result"`
+	Message     string      `json:"message,omitempty"`
+}
+
+// PCIDeviceNameForHostname names the PCIDevice of dev after the node, the vendor and device
+// IDs and the full address, e.g. node1-intel-8086-1521-0000-00-1f-6
+func PCIDeviceNameForHostname(dev *pci.PCI, nodeName string) string {
+	addrDNSsafe := strings.ReplaceAll(strings.ReplaceAll(dev.Addr, ":", "-"), ".", "-")
+	if addr, err := ParsePCIAddress(dev.Addr); err == nil {
+		addrDNSsafe = addr.DNSLabel()
+	}
+	return pciDeviceName(dev, nodeName, addrDNSsafe)
+}
+
+// LegacyPCIDeviceNameForHostname is how PCIDevices used to be named, with the separators
+// stripped from the address, which is ambiguous across PCI domains. PCIDevices with a
+// legacy name are renamed by the PCI Devices controller.
+func LegacyPCIDeviceNameForHostname(dev *pci.PCI, nodeName string) string {
 	addrDNSsafe := strings.ReplaceAll(strings.ReplaceAll(dev.Addr, ":", ""), ".", "")
+	return pciDeviceName(dev, nodeName, addrDNSsafe)
+}
+
+// uRootNames returns the vendor and device names from u-root's built-in database. The names
+// u-root reads from sysfs are just the hex IDs, so they are always looked up. This is only
+// done when needed, as the pci.ids database is preferred.
+func uRootNames(dev *pci.PCI) (string, string) {
+	named := *dev
+	named.SetVendorDeviceName()
+	return named.VendorName, named.DeviceName
+}
+
+func pciDeviceName(dev *pci.PCI, nodeName string, addrDNSsafe string) string {
+	// PCIDevices have always been named after u-root's vendor names, which keeps the names stable
+	// whatever pci.ids database is used
+	vendorName, _ := uRootNames(dev)
+	vendorName = strings.ToLower(
+		strings.Split(vendorName, " ")[0],
+	)
 	return fmt.Sprintf(
 		"%s-%s-%x-%x-%s",
-		hostname,
+		nodeName,
 		vendorName,
 		dev.Vendor,
 		dev.Device,
@@ -78,18 +580,19 @@ func PCIDeviceNameForHostname(dev *pci.PCI, hostname string) string {
 	)
 }
 
-func NewPCIDeviceForHostname(dev *pci.PCI, hostname string) PCIDevice {
-	name := PCIDeviceNameForHostname(dev, hostname)
+func NewPCIDeviceForHostname(dev *pci.PCI, nodeName string) PCIDevice {
+	name := PCIDeviceNameForHostname(dev, nodeName)
+	_, deviceName := uRootNames(dev)
 	pciDevice := PCIDevice{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: name,
 		},
 		Status: PCIDeviceStatus{
-			Address:     dev.Addr,
+			Address:     CanonicalPCIAddress(dev.Addr),
 			VendorId:    int(dev.Vendor), // upcasting a uint16 to an int is safe
 			DeviceId:    int(dev.Device),
-			NodeName:    hostname,
-			Description: dev.DeviceName,
+			NodeName:    nodeName,
+			Description: deviceName,
 		},
 	}
 	return pciDevice
@@ -2,9 +2,14 @@ package v1beta1
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/harvester/pcidevices/pkg/pciids"
+	"github.com/harvester/pcidevices/pkg/sysfs"
+	"github.com/harvester/pcidevices/pkg/sysfs/sysfstest"
 	"github.com/u-root/u-root/pkg/pci"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -31,13 +36,14 @@ func TestNewPCIDeviceForName(t *testing.T) {
 			},
 			want: PCIDevice{
 				ObjectMeta: v1.ObjectMeta{
-					Name: "deepgreen-intel-8086-1521-001f6",
+					Name: "deepgreen-intel-8086-1521-0000-00-1f-6",
 				},
 				Status: PCIDeviceStatus{
-					NodeName: "deepgreen",
-					VendorId: 0x8086,
-					DeviceId: 0x1521,
-					Address:  "00:1f.6",
+					NodeName:    "deepgreen",
+					VendorId:    0x8086,
+					DeviceId:    0x1521,
+					Address:     "0000:00:1f.6",
+					Description: "I350 Gigabit Network Connection",
 				},
 			},
 		},
@@ -51,3 +57,236 @@ func TestNewPCIDeviceForName(t *testing.T) {
 		})
 	}
 }
+
+func TestPCIDeviceNameIsCanonical(t *testing.T) {
+	short := &pci.PCI{VendorName: "Intel Corporation", Vendor: 0x8086, Device: 0x1521, Addr: "00:1f.6"}
+	full := &pci.PCI{VendorName: "Intel Corporation", Vendor: 0x8086, Device: 0x1521, Addr: "0000:00:1F.6"}
+	if PCIDeviceNameForHostname(short, "node1") != PCIDeviceNameForHostname(full, "node1") {
+		t.Fatalf("expected the same name, got %s and %s", PCIDeviceNameForHostname(short, "node1"), PCIDeviceNameForHostname(full, "node1"))
+	}
+	vmd := &pci.PCI{VendorName: "Samsung Electronics Co Ltd", Vendor: 0x144d, Device: 0xa80a, Addr: "10000:e1:00.0"}
+	if name := PCIDeviceNameForHostname(vmd, "node1"); name != "node1-samsung-144d-a80a-10000-e1-00-0" {
+		t.Fatalf("unexpected name %s", name)
+	}
+	if name := LegacyPCIDeviceNameForHostname(short, "node1"); name != "node1-intel-8086-1521-001f6" {
+		t.Fatalf("unexpected legacy name %s", name)
+	}
+}
+
+func TestPCIDeviceNameFromSysfs(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{Addr: "0000:00:1f.6", Vendor: 0x8086, Device: 0x15b8, Class: 0x020000})
+	fs := sysfs.New(tree.Root, tree.WriteModulesAlias())
+	dev, err := fs.Device("0000:00:1f.6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The names read from sysfs are hex IDs, the vendor name comes from u-root's database
+	if name := PCIDeviceNameForHostname(dev, "node1"); name != "node1-intel-8086-15b8-0000-00-1f-6" {
+		t.Fatalf("unexpected name %s", name)
+	}
+	if name := LegacyPCIDeviceNameForHostname(dev, "node1"); name != "node1-intel-8086-15b8-0000001f6" {
+		t.Fatalf("unexpected legacy name %s", name)
+	}
+}
+
+func TestUpdateLabels(t *testing.T) {
+	numaNode := 1
+	pd := PCIDevice{
+		ObjectMeta: v1.ObjectMeta{
+			Labels: map[string]string{"app": "gpu"},
+		},
+		Status: PCIDeviceStatus{
+			NUMANode:          &numaNode,
+			ClassId:           0x03,
+			SubclassId:        0x00,
+			SubsystemVendorId: 0x1458,
+			SubsystemDeviceId: 0x3fe5,
+		},
+	}
+	if !pd.UpdateLabels() {
+		t.Fatal("expected labels to change")
+	}
+	if pd.Labels[ClassLabel] != "0300" || pd.Labels[SubsystemVendorIdLabel] != "1458" || pd.Labels[SubsystemDeviceIdLabel] != "3fe5" {
+		t.Fatalf("unexpected labels %v", pd.Labels)
+	}
+	if pd.Labels[NUMANodeLabel] != "1" || pd.Labels["app"] != "gpu" {
+		t.Fatalf("unexpected labels %v", pd.Labels)
+	}
+	if pd.UpdateLabels() {
+		t.Fatal("expected labels to be unchanged")
+	}
+	pd.Status.NUMANode = nil
+	if !pd.UpdateLabels() {
+		t.Fatal("expected labels to change")
+	}
+	if _, found := pd.Labels[NUMANodeLabel]; found {
+		t.Fatalf("expected %s to be removed, got %v", NUMANodeLabel, pd.Labels)
+	}
+}
+
+func TestLinkDegradedCondition(t *testing.T) {
+	tests := []struct {
+		name           string
+		link           PCIeLinkStatus
+		expectedStatus v1.ConditionStatus
+		expectedReason string
+	}{
+		{
+			name:           "link at maximum",
+			link:           PCIeLinkStatus{CurrentSpeed: "16.0 GT/s PCIe", CurrentWidth: 16, MaxSpeed: "16.0 GT/s PCIe", MaxWidth: 16},
+			expectedStatus: v1.ConditionFalse,
+			expectedReason: "LinkAtMaximum",
+		},
+		{
+			name:           "GPU behind a bad riser",
+			link:           PCIeLinkStatus{CurrentSpeed: "16.0 GT/s PCIe", CurrentWidth: 4, MaxSpeed: "16.0 GT/s PCIe", MaxWidth: 16},
+			expectedStatus: v1.ConditionTrue,
+			expectedReason: "WidthDegraded",
+		},
+		{
+			name:           "slower speed",
+			link:           PCIeLinkStatus{CurrentSpeed: "2.5 GT/s", CurrentWidth: 8, MaxSpeed: "8 GT/s", MaxWidth: 8},
+			expectedStatus: v1.ConditionTrue,
+			expectedReason: "SpeedDegraded",
+		},
+		{
+			name:           "unknown speed",
+			link:           PCIeLinkStatus{CurrentSpeed: "Unknown", CurrentWidth: 1, MaxSpeed: "5.0 GT/s PCIe", MaxWidth: 1},
+			expectedStatus: v1.ConditionFalse,
+			expectedReason: "LinkAtMaximum",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition := tt.link.DegradedCondition()
+			if condition.Status != tt.expectedStatus || condition.Reason != tt.expectedReason {
+				t.Errorf("expected %s/%s, got %s/%s", tt.expectedStatus, tt.expectedReason, condition.Status, condition.Reason)
+			}
+		})
+	}
+}
+
+func TestUpdateHealth(t *testing.T) {
+	var status PCIDeviceStatus
+	healthy := func() v1.ConditionStatus {
+		if condition := meta.FindStatusCondition(status.Conditions, PCIDeviceHealthy); condition != nil {
+			return condition.Status
+		}
+		return v1.ConditionUnknown
+	}
+
+	// Errors from before the first check don't count
+	status.updateHealth(&AERCounters{Correctable: 5, NonFatal: 1})
+	if healthy() != v1.ConditionTrue {
+		t.Fatalf("expected a new device to be healthy, got %v", status.Conditions)
+	}
+	// Correctable errors don't make the device unhealthy
+	status.updateHealth(&AERCounters{Correctable: 9, NonFatal: 1})
+	if healthy() != v1.ConditionTrue {
+		t.Fatalf("expected correctable errors to be tolerated, got %v", status.Conditions)
+	}
+	status.updateHealth(&AERCounters{Correctable: 9, NonFatal: 2})
+	if healthy() != v1.ConditionFalse {
+		t.Fatalf("expected a non-fatal error to make the device unhealthy, got %v", status.Conditions)
+	}
+	// It stays unhealthy without new errors, until the counters are reset
+	status.updateHealth(&AERCounters{Correctable: 9, NonFatal: 2})
+	if healthy() != v1.ConditionFalse {
+		t.Fatalf("expected the device to stay unhealthy, got %v", status.Conditions)
+	}
+	status.updateHealth(&AERCounters{})
+	if healthy() != v1.ConditionTrue {
+		t.Fatalf("expected the device to be healthy after a reset, got %v", status.Conditions)
+	}
+	status.updateHealth(&AERCounters{Fatal: 1})
+	if healthy() != v1.ConditionFalse {
+		t.Fatalf("expected a fatal error to make the device unhealthy, got %v", status.Conditions)
+	}
+	// Without AER there's no way to tell
+	status.updateHealth(nil)
+	if healthy() != v1.ConditionUnknown {
+		t.Fatalf("expected no health condition without AER, got %v", status.Conditions)
+	}
+}
+
+func TestUpdateNames(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	nic := sysfstest.Device{
+		Addr:            "0000:22:00.0",
+		Vendor:          0x8086,
+		Device:          0x1557,
+		Class:           0x020000,
+		SubsystemVendor: 0x1dcf,
+		SubsystemDevice: 0x0317,
+	}
+	tree.AddDevice(nic)
+	fs := sysfs.New(tree.Root, tree.WriteModulesAlias())
+	dev, err := fs.Device(nic.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := pciids.Parse(strings.NewReader("8086  Intel Corporation\n" +
+		"\t1557  82599 10 Gigabit Network Connection\n" +
+		"\t\t1dcf 0317  10GbE SFP+ Dual Port Adapter\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status PCIDeviceStatus
+	status.Update(dev, "node1", fs, ids)
+	if status.VendorName != "Intel Corporation" || status.DeviceName != "82599 10 Gigabit Network Connection" {
+		t.Fatalf("unexpected names %q %q", status.VendorName, status.DeviceName)
+	}
+	if status.SubsystemName != "10GbE SFP+ Dual Port Adapter" {
+		t.Fatalf("unexpected subsystem name %q", status.SubsystemName)
+	}
+	expected := "Ethernet controller: Intel Corporation 82599 10 Gigabit Network Connection"
+	if status.Description != expected {
+		t.Fatalf("expected description %q, got %q", expected, status.Description)
+	}
+
+	// Without a database, the names u-root knows are used
+	status.Update(dev, "node1", fs, nil)
+	_, deviceName := uRootNames(dev)
+	if status.VendorName != "Intel Corporation" || status.DeviceName != deviceName || status.SubsystemName != "" {
+		t.Fatalf("unexpected names %q %q %q", status.VendorName, status.DeviceName, status.SubsystemName)
+	}
+}
+
+func TestUpdateRegions(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	gpu := sysfstest.Device{
+		Addr:  "0000:01:00.0",
+		Class: 0x030000,
+		Extra: map[string]string{"resource": strings.Join([]string{
+			"0x00000000fb000000 0x00000000fbffffff 0x0000000000040200",
+			"0x0000006000000000 0x00000067ffffffff 0x000000000014220c",
+			"0x0000000000000000 0x0000000000000000 0x0000000000000000",
+			"0x0000000000000000 0x0000000000000000 0x0000000000000000",
+			"0x0000000000000000 0x0000000000000000 0x0000000000000000",
+			"0x000000000000e000 0x000000000000e07f 0x0000000000040101",
+			"0x00000000fc000000 0x00000000fc07ffff 0x0000000000046200",
+		}, "\n")},
+	}
+	tree.AddDevice(gpu)
+	fs := sysfs.New(tree.Root, tree.WriteModulesAlias())
+	dev, err := fs.Device(gpu.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var status PCIDeviceStatus
+	status.Update(dev, "node1", fs, nil)
+	expected := []PCIRegion{
+		{BAR: 0, Type: PCIRegionMemory, Size: 16 << 20},
+		{BAR: 1, Type: PCIRegionMemory, Size: 32 << 30, Is64Bit: true, Prefetchable: true},
+		{BAR: 5, Type: PCIRegionIO, Size: 128},
+	}
+	if !reflect.DeepEqual(status.Regions, expected) {
+		t.Fatalf("expected regions %+v, got %+v", expected, status.Regions)
+	}
+	if status.ExpansionROM == nil || status.ExpansionROM.Size != 512<<10 {
+		t.Fatalf("unexpected expansion ROM %+v", status.ExpansionROM)
+	}
+}
@@ -6,6 +6,36 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// PCIDeviceClaimOrphaned is the condition set on a PCIDeviceClaim whose node no longer exists
+	PCIDeviceClaimOrphaned = "Orphaned"
+	// PCIDeviceClaimBound is the condition that tells whether the device is bound to vfio-pci,
+	// and why not
+	PCIDeviceClaimBound = "Bound"
+	// PCIDeviceClaimReleased is the condition set on a deleted claim while its device is being
+	// bound back to its original driver
+	PCIDeviceClaimReleased = "Released"
+	// PCIDeviceClaimFinalizer keeps a claim around until the agent on its node released the device
+	PCIDeviceClaimFinalizer = "pcidevices.harvesterhci.io/release"
+)
+
+// PCIDeviceClaimPhase summarizes where a PCIDeviceClaim is in its lifecycle
+type PCIDeviceClaimPhase string
+
+const (
+	// PCIDeviceClaimPhasePending claims wait for their device to be found
+	PCIDeviceClaimPhasePending PCIDeviceClaimPhase = "Pending"
+	// PCIDeviceClaimPhaseBinding claims have their device being bound to vfio-pci
+	PCIDeviceClaimPhaseBinding PCIDeviceClaimPhase = "Binding"
+	// PCIDeviceClaimPhaseBound claims have their device bound to vfio-pci
+	PCIDeviceClaimPhaseBound PCIDeviceClaimPhase = "Bound"
+	// PCIDeviceClaimPhaseReleasing claims are deleted, and wait for their device to be bound back to its driver
+	PCIDeviceClaimPhaseReleasing PCIDeviceClaimPhase = "Releasing"
+	// PCIDeviceClaimPhaseFailed claims failed to bind or release their device, the conditions tell why.
+	// The agent keeps retrying.
+	PCIDeviceClaimPhaseFailed PCIDeviceClaimPhase = "Failed"
+)
+
 // +genclient
 // +genclient:nonNamespaced
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -26,11 +56,33 @@ type PCIDeviceClaimSpec struct {
 	UserName string `json:"userName"`
 }
 
+// NodeAddr identifies the claimed device by node and canonical address, so that
+// claims match their PCIDevice however the address was written
 func (s PCIDeviceClaimSpec) NodeAddr() string {
-	return fmt.Sprintf("%s-%s", s.NodeName, s.Address)
+	return fmt.Sprintf("%s-%s", s.NodeName, CanonicalPCIAddress(s.Address))
+}
+
+func (s PCIDeviceClaimSpec) PCIAddress() (PCIAddress, error) {
+	return ParsePCIAddress(s.Address)
 }
 
 type PCIDeviceClaimStatus struct {
-	KernelDriverToUnbind string `json:"kernelDriverToUnbind"`
-	PassthroughEnabled   bool   `json:"passthroughEnabled"`
+	KernelDriverToUnbind string              `json:"kernelDriverToUnbind"`
+	PassthroughEnabled   bool                `json:"passthroughEnabled"`
+	Phase                PCIDeviceClaimPhase `json:"phase,omitempty"`
+	// LastPhaseTransitionTime is when the claim entered its phase
+	LastPhaseTransitionTime *metav1.Time `json:"lastPhaseTransitionTime,omitempty"`
+	// ObservedGeneration is the generation of the claim the status was last updated for
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// SetPhase moves the claim to the phase, recording the time if it changed
+func (s *PCIDeviceClaimStatus) SetPhase(phase PCIDeviceClaimPhase) {
+	if s.Phase == phase {
+		return
+	}
+	now := metav1.Now()
+	s.Phase = phase
+	s.LastPhaseTransitionTime = &now
 }
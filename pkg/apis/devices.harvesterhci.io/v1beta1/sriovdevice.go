@@ -0,0 +1,42 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// SRIOVDeviceVFsProvisioned is the condition set on an SRIOVDevice once its PF has the requested number of VFs
+	SRIOVDeviceVFsProvisioned = "VFsProvisioned"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+// an SRIOVDevice declares the number of VFs to create on an SR-IOV physical function
+type SRIOVDevice struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SRIOVDeviceSpec   `json:"spec,omitempty"`
+	Status SRIOVDeviceStatus `json:"status,omitempty"`
+}
+
+type SRIOVDeviceSpec struct {
+	// Address is the address of the PF
+	Address  string `json:"address"`
+	NodeName string `json:"nodeName"`
+	NumVFs   int    `json:"numVFs"`
+}
+
+func (s SRIOVDeviceSpec) PCIAddress() (PCIAddress, error) {
+	return ParsePCIAddress(s.Address)
+}
+
+type SRIOVDeviceStatus struct {
+	TotalVFs    int                `json:"totalVFs,omitempty"`
+	NumVFs      int                `json:"numVFs"`
+	VFAddresses []string           `json:"vfAddresses,omitempty"`
+	Conditions  []metav1.Condition `json:"conditions,omitempty"`
+}
@@ -22,15 +22,48 @@ limitations under the License.
 package v1beta1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AERCounters) DeepCopyInto(out *AERCounters) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AERCounters.
+func (in *AERCounters) DeepCopy() *AERCounters {
+	if in == nil {
+		return nil
+	}
+	out := new(AERCounters)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIAddress) DeepCopyInto(out *PCIAddress) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIAddress.
+func (in *PCIAddress) DeepCopy() *PCIAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PCIDevice) DeepCopyInto(out *PCIDevice) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 	return
 }
@@ -59,7 +92,7 @@ func (in *PCIDeviceClaim) DeepCopyInto(out *PCIDeviceClaim) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -133,6 +166,17 @@ func (in *PCIDeviceClaimSpec) DeepCopy() *PCIDeviceClaimSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PCIDeviceClaimStatus) DeepCopyInto(out *PCIDeviceClaimStatus) {
 	*out = *in
+	if in.LastPhaseTransitionTime != nil {
+		in, out := &in.LastPhaseTransitionTime, &out.LastPhaseTransitionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -179,9 +223,31 @@ func (in *PCIDeviceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIDeviceResetStatus) DeepCopyInto(out *PCIDeviceResetStatus) {
+	*out = *in
+	in.RequestedAt.DeepCopyInto(&out.RequestedAt)
+	in.Time.DeepCopyInto(&out.Time)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIDeviceResetStatus.
+func (in *PCIDeviceResetStatus) DeepCopy() *PCIDeviceResetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIDeviceResetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PCIDeviceSpec) DeepCopyInto(out *PCIDeviceSpec) {
 	*out = *in
+	if in.ResetRequestedAt != nil {
+		in, out := &in.ResetRequestedAt, &out.ResetRequestedAt
+		*out = (*in).DeepCopy()
+	}
 	return
 }
 
@@ -203,6 +269,63 @@ func (in *PCIDeviceStatus) DeepCopyInto(out *PCIDeviceStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.IOMMUGroupDevices != nil {
+		in, out := &in.IOMMUGroupDevices, &out.IOMMUGroupDevices
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.UpstreamBridges != nil {
+		in, out := &in.UpstreamBridges, &out.UpstreamBridges
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NUMANode != nil {
+		in, out := &in.NUMANode, &out.NUMANode
+		*out = new(int)
+		**out = **in
+	}
+	if in.Regions != nil {
+		in, out := &in.Regions, &out.Regions
+		*out = make([]PCIRegion, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExpansionROM != nil {
+		in, out := &in.ExpansionROM, &out.ExpansionROM
+		*out = new(PCIRegion)
+		**out = **in
+	}
+	if in.Link != nil {
+		in, out := &in.Link, &out.Link
+		*out = new(PCIeLinkStatus)
+		**out = **in
+	}
+	if in.SRIOV != nil {
+		in, out := &in.SRIOV, &out.SRIOV
+		*out = new(SRIOVStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ResetMethods != nil {
+		in, out := &in.ResetMethods, &out.ResetMethods
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastReset != nil {
+		in, out := &in.LastReset, &out.LastReset
+		*out = new(PCIDeviceResetStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AER != nil {
+		in, out := &in.AER, &out.AER
+		*out = new(AERCounters)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -215,3 +338,161 @@ func (in *PCIDeviceStatus) DeepCopy() *PCIDeviceStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIRegion) DeepCopyInto(out *PCIRegion) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIRegion.
+func (in *PCIRegion) DeepCopy() *PCIRegion {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIRegion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PCIeLinkStatus) DeepCopyInto(out *PCIeLinkStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PCIeLinkStatus.
+func (in *PCIeLinkStatus) DeepCopy() *PCIeLinkStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PCIeLinkStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SRIOVDevice) DeepCopyInto(out *SRIOVDevice) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SRIOVDevice.
+func (in *SRIOVDevice) DeepCopy() *SRIOVDevice {
+	if in == nil {
+		return nil
+	}
+	out := new(SRIOVDevice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SRIOVDevice) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SRIOVDeviceList) DeepCopyInto(out *SRIOVDeviceList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]SRIOVDevice, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SRIOVDeviceList.
+func (in *SRIOVDeviceList) DeepCopy() *SRIOVDeviceList {
+	if in == nil {
+		return nil
+	}
+	out := new(SRIOVDeviceList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SRIOVDeviceList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SRIOVDeviceSpec) DeepCopyInto(out *SRIOVDeviceSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SRIOVDeviceSpec.
+func (in *SRIOVDeviceSpec) DeepCopy() *SRIOVDeviceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SRIOVDeviceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SRIOVDeviceStatus) DeepCopyInto(out *SRIOVDeviceStatus) {
+	*out = *in
+	if in.VFAddresses != nil {
+		in, out := &in.VFAddresses, &out.VFAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SRIOVDeviceStatus.
+func (in *SRIOVDeviceStatus) DeepCopy() *SRIOVDeviceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SRIOVDeviceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SRIOVStatus) DeepCopyInto(out *SRIOVStatus) {
+	*out = *in
+	if in.VirtFns != nil {
+		in, out := &in.VirtFns, &out.VirtFns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SRIOVStatus.
+func (in *SRIOVStatus) DeepCopy() *SRIOVStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SRIOVStatus)
+	in.DeepCopyInto(out)
+	return out
+}
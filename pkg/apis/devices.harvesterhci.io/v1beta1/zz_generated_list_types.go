@@ -57,3 +57,20 @@ func NewPCIDeviceClaim(namespace, name string, obj PCIDeviceClaim) *PCIDeviceCla
 	obj.Namespace = namespace
 	return &obj
 }
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SRIOVDeviceList is a list of SRIOVDevice resources
+type SRIOVDeviceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []SRIOVDevice `json:"items"`
+}
+
+func NewSRIOVDevice(namespace, name string, obj SRIOVDevice) *SRIOVDevice {
+	obj.APIVersion, obj.Kind = SchemeGroupVersion.WithKind("SRIOVDevice").ToAPIVersionAndKind()
+	obj.Name = name
+	obj.Namespace = namespace
+	return &obj
+}
@@ -30,6 +30,7 @@ import (
 var (
 	PCIDeviceResourceName      = "pcidevices"
 	PCIDeviceClaimResourceName = "pcideviceclaims"
+	SRIOVDeviceResourceName    = "sriovdevices"
 )
 
 // SchemeGroupVersion is group version used to register these objects
@@ -57,6 +58,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&PCIDeviceList{},
 		&PCIDeviceClaim{},
 		&PCIDeviceClaimList{},
+		&SRIOVDevice{},
+		&SRIOVDeviceList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -0,0 +1,129 @@
+package deviceplugin
+
+import (
+	"context"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/deviceplugin"
+	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+const (
+	vfioDriver = "vfio-pci"
+	// syncKey is enqueued on the claims controller to sync the device plugins, so that a burst of
+	// changes is handled once. Claim names can't contain underscores, so it can't be a claim.
+	syncKey = "_deviceplugin_sync"
+)
+
+type syncer interface {
+	Sync(devices map[string][]deviceplugin.Device) error
+}
+
+type Handler struct {
+	deviceCache ctl.PCIDeviceCache
+	claimCache  ctl.PCIDeviceClaimCache
+	plugins     syncer
+	nodeName    string
+	enqueue     func(name string)
+}
+
+// Register starts the controller that advertises the claimed devices of the node to kubelet,
+// with one device plugin per resource name
+func Register(
+	ctx context.Context,
+	pd ctl.PCIDeviceController,
+	pdc ctl.PCIDeviceClaimController,
+	pluginDir string,
+	nodeName string,
+) {
+	logrus.Info("Registering Device Plugins controller")
+	manager := deviceplugin.NewManager(pluginDir)
+	handler := &Handler{
+		deviceCache: pd.Cache(),
+		claimCache:  pdc.Cache(),
+		plugins:     manager,
+		nodeName:    nodeName,
+		enqueue:     pdc.Enqueue,
+	}
+	go manager.Run(ctx)
+	pd.OnChange(ctx, "pcidevice-deviceplugin", handler.OnDeviceChange)
+	pdc.OnChange(ctx, "pcideviceclaim-deviceplugin", handler.OnClaimChange)
+}
+
+// OnDeviceChange syncs the device plugins when a device of the node changes. Deleted devices
+// are synced too, as they can't be told apart.
+func (h *Handler) OnDeviceChange(key string, pd *v1beta1.PCIDevice) (*v1beta1.PCIDevice, error) {
+	if pd == nil || pd.Status.NodeName == h.nodeName {
+		h.enqueue(syncKey)
+	}
+	return pd, nil
+}
+
+// OnClaimChange syncs the device plugins when a claim of the node changes, and carries out the sync
+func (h *Handler) OnClaimChange(key string, pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	if key == syncKey {
+		return pdc, h.sync()
+	}
+	if pdc == nil || pdc.Spec.NodeName == h.nodeName {
+		h.enqueue(syncKey)
+	}
+	return pdc, nil
+}
+
+func (h *Handler) sync() error {
+	devices, err := h.devices()
+	if err != nil {
+		return err
+	}
+	return h.plugins.Sync(devices)
+}
+
+// devices returns the devices of the node that are claimed and bound to vfio-pci, by resource name
+func (h *Handler) devices() (map[string][]deviceplugin.Device, error) {
+	pdcs, err := h.claimCache.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	claimed := map[string]bool{}
+	for _, pdc := range pdcs {
+		if pdc.Spec.NodeName == h.nodeName && pdc.DeletionTimestamp == nil && pdc.Status.PassthroughEnabled {
+			claimed[pdc.Spec.NodeAddr()] = true
+		}
+	}
+	pds, err := h.deviceCache.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := map[string][]deviceplugin.Device{}
+	for _, pd := range pds {
+		if pd.Status.NodeName != h.nodeName || pd.Status.KernelDriverInUse != vfioDriver {
+			continue
+		}
+		nodeAddr := pd.Status.NodeName + "-" + v1beta1.CanonicalPCIAddress(pd.Status.Address)
+		if !claimed[nodeAddr] || pd.Status.ResourceName == "" {
+			continue
+		}
+		numaNode := -1
+		if pd.Status.NUMANode != nil {
+			numaNode = *pd.Status.NUMANode
+		}
+		result[pd.Status.ResourceName] = append(result[pd.Status.ResourceName], deviceplugin.Device{
+			Address:    pd.Status.Address,
+			IOMMUGroup: pd.Status.IOMMUGroup,
+			NUMANode:   numaNode,
+			// Kubelet doesn't allocate unhealthy devices to new pods
+			Healthy: pd.IsHealthy(),
+		})
+	}
+	// The cache lists in no particular order
+	for _, devices := range result {
+		sort.Slice(devices, func(i, j int) bool {
+			return devices[i].Address < devices[j].Address
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package deviceplugin
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/deviceplugin"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+func newDevice(nodeName string, address string, driver string) *v1beta1.PCIDevice {
+	return &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName + "-" + address},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:           address,
+			NodeName:          nodeName,
+			ResourceName:      "pcidevices.harvesterhci.io/10de-1eb8",
+			KernelDriverInUse: driver,
+			IOMMUGroup:        "13",
+		},
+	}
+}
+
+func newClaim(nodeName string, address string, passthroughEnabled bool) *v1beta1.PCIDeviceClaim {
+	return &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName + "-" + address},
+		Spec:       v1beta1.PCIDeviceClaimSpec{NodeName: nodeName, Address: address},
+		Status:     v1beta1.PCIDeviceClaimStatus{PassthroughEnabled: passthroughEnabled},
+	}
+}
+
+func TestDevices(t *testing.T) {
+	unhealthy := newDevice("node1", "0000:04:00.0", "vfio-pci")
+	unhealthy.Status.IOMMUGroup = "14"
+	unhealthy.Status.Conditions = []metav1.Condition{{Type: v1beta1.PCIDeviceHealthy, Status: metav1.ConditionFalse}}
+	client := fake.NewSimpleClientset(
+		// Claimed and bound
+		newDevice("node1", "0000:01:00.0", "vfio-pci"),
+		newClaim("node1", "0000:01:00.0", true),
+		// Claimed, but still bound to its driver
+		newDevice("node1", "0000:02:00.0", "nouveau"),
+		newClaim("node1", "0000:02:00.0", false),
+		// Claimed and bound, but reported uncorrectable errors
+		unhealthy,
+		newClaim("node1", "0000:04:00.0", true),
+		// Bound, but not claimed
+		newDevice("node1", "0000:03:00.0", "vfio-pci"),
+		// On another node
+		newDevice("node2", "0000:01:00.0", "vfio-pci"),
+		newClaim("node2", "0000:01:00.0", true),
+	)
+	h := &Handler{
+		deviceCache: fakeclients.NewPCIDeviceCache(client.DevicesV1beta1().PCIDevices),
+		claimCache:  fakeclients.NewPCIDeviceClaimCache(client.DevicesV1beta1().PCIDeviceClaims),
+		nodeName:    "node1",
+	}
+	devices, err := h.devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]deviceplugin.Device{
+		"pcidevices.harvesterhci.io/10de-1eb8": {
+			{Address: "0000:01:00.0", IOMMUGroup: "13", NUMANode: -1, Healthy: true},
+			{Address: "0000:04:00.0", IOMMUGroup: "14", NUMANode: -1, Healthy: false},
+		},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Fatalf("expected %v, got %v", expected, devices)
+	}
+}
+
+func TestOnChangeEnqueuesSync(t *testing.T) {
+	var enqueued []string
+	h := &Handler{
+		nodeName: "node1",
+		enqueue:  func(name string) { enqueued = append(enqueued, name) },
+	}
+	// Only changes of the node are synced, once for a burst of them
+	if _, err := h.OnDeviceChange("node2-0000:01:00.0", newDevice("node2", "0000:01:00.0", "vfio-pci")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.OnClaimChange("node2-0000:01:00.0", newClaim("node2", "0000:01:00.0", true)); err != nil {
+		t.Fatal(err)
+	}
+	if len(enqueued) != 0 {
+		t.Fatalf("expected changes of other nodes to be ignored, got %v", enqueued)
+	}
+	if _, err := h.OnDeviceChange("node1-0000:01:00.0", newDevice("node1", "0000:01:00.0", "vfio-pci")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.OnClaimChange("node1-0000:01:00.0", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(enqueued, []string{syncKey, syncKey}) {
+		t.Fatalf("expected the sync to be enqueued, got %v", enqueued)
+	}
+}
@@ -0,0 +1,132 @@
+package devicereset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/sysfs"
+)
+
+const (
+	vfioDriver = "vfio-pci"
+	vfioDir    = "/dev/vfio"
+)
+
+type Handler struct {
+	client   ctl.PCIDeviceClient
+	sysfs    *sysfs.SysFS
+	nodeName string
+	// openGroup opens the vfio group of a device. vfio only lets one process open a group,
+	// so while it's open, no VM can be using the device.
+	openGroup func(group string) (io.Closer, error)
+}
+
+// Register starts the controller that resets the PCIDevices of the node when a reset is requested
+// through spec.resetRequestedAt
+func Register(
+	ctx context.Context,
+	pd ctl.PCIDeviceController,
+	fs *sysfs.SysFS,
+	nodeName string,
+) {
+	logrus.Info("Registering PCI Device Reset controller")
+	handler := &Handler{
+		client:    pd,
+		sysfs:     fs,
+		nodeName:  nodeName,
+		openGroup: openVFIOGroup,
+	}
+	pd.OnChange(ctx, "pcidevice-reset", handler.OnChange)
+}
+
+func openVFIOGroup(group string) (io.Closer, error) {
+	return os.OpenFile(filepath.Join(vfioDir, group), os.O_RDWR, 0)
+}
+
+// OnChange carries out a pending reset of a device of the node, and records the outcome
+func (h *Handler) OnChange(key string, pd *v1beta1.PCIDevice) (*v1beta1.PCIDevice, error) {
+	if pd == nil || pd.DeletionTimestamp != nil || pd.Status.NodeName != h.nodeName || !pd.ResetPending() {
+		return pd, nil
+	}
+	pdCopy := pd.DeepCopy()
+	pdCopy.Status.LastReset = h.reset(pd)
+	if pdCopy.Status.LastReset.Result == v1beta1.PCIDeviceResetSucceeded {
+		logrus.Infof("Reset PCI device %s", pd.Name)
+	} else {
+		logrus.Warnf("PCI device %s was not reset: %s", pd.Name, pdCopy.Status.LastReset.Message)
+	}
+	return h.client.UpdateStatus(pdCopy)
+}
+
+func (h *Handler) reset(pd *v1beta1.PCIDevice) *v1beta1.PCIDeviceResetStatus {
+	result := &v1beta1.PCIDeviceResetStatus{
+		RequestedAt: *pd.Spec.ResetRequestedAt,
+		Time:        metav1.Now(),
+		Method:      pd.Spec.ResetMethod,
+	}
+	fail := func(reason string, format string, args ...interface{}) *v1beta1.PCIDeviceResetStatus {
+		result.Result = reason
+		result.Message = fmt.Sprintf(format, args...)
+		return result
+	}
+	addr := pd.Status.Address
+
+	methods, err := h.sysfs.ResetMethods(addr)
+	if err != nil {
+		return fail(v1beta1.PCIDeviceResetFailed, "error reading the reset methods: %v", err)
+	}
+	if pd.Spec.ResetMethod != "" && !contains(methods, pd.Spec.ResetMethod) {
+		return fail(v1beta1.PCIDeviceResetFailed, "reset method %s is not supported, the device supports [%s]",
+			pd.Spec.ResetMethod, strings.Join(methods, " "))
+	}
+
+	driver, err := h.sysfs.Driver(addr)
+	if err != nil && err != sysfs.ErrNoDriver {
+		return fail(v1beta1.PCIDeviceResetFailed, "error reading the driver: %v", err)
+	}
+	switch driver {
+	case "":
+	case vfioDriver:
+		group, err := h.sysfs.IOMMUGroup(addr)
+		if err != nil {
+			return fail(v1beta1.PCIDeviceResetFailed, "error reading the IOMMU group: %v", err)
+		}
+		// Hold the group while resetting, so that no VM can start using the device meanwhile
+		closer, err := h.openGroup(group)
+		if errors.Is(err, syscall.EBUSY) {
+			return fail(v1beta1.PCIDeviceResetRefused, "the device is in use by a VM")
+		}
+		if err != nil {
+			return fail(v1beta1.PCIDeviceResetFailed, "error opening vfio group %s: %v", group, err)
+		}
+		defer closer.Close()
+	default:
+		return fail(v1beta1.PCIDeviceResetRefused, "the device is bound to %s, it has to be unbound or bound to %s", driver, vfioDriver)
+	}
+
+	if err := h.sysfs.Reset(addr, pd.Spec.ResetMethod); err != nil {
+		return fail(v1beta1.PCIDeviceResetFailed, "%v", err)
+	}
+	result.Result = v1beta1.PCIDeviceResetSucceeded
+	return result
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
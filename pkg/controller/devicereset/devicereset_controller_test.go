@@ -0,0 +1,114 @@
+package devicereset
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/sysfs"
+	"github.com/harvester/pcidevices/pkg/sysfs/sysfstest"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+const addr = "0000:01:00.0"
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func newDevice(driver string, method string) *v1beta1.PCIDevice {
+	requestedAt := metav1.NewTime(time.Date(2022, 9, 1, 12, 0, 0, 0, time.UTC))
+	return &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1-" + addr},
+		Spec:       v1beta1.PCIDeviceSpec{ResetRequestedAt: &requestedAt, ResetMethod: method},
+		Status:     v1beta1.PCIDeviceStatus{Address: addr, NodeName: "node1", KernelDriverInUse: driver},
+	}
+}
+
+// newHandler returns a handler for a node with a resettable GPU bound to driver,
+// whose vfio group is opened with groupErr
+func newHandler(t *testing.T, pd *v1beta1.PCIDevice, groupErr error) (*Handler, *sysfs.SysFS) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{
+		Addr:   addr,
+		Driver: pd.Status.KernelDriverInUse,
+		Extra:  map[string]string{"reset_method": "flr bus", "reset": ""},
+	})
+	tree.SetIOMMUGroup(addr, "13")
+	fs := sysfs.New(tree.Root, "")
+	client := fake.NewSimpleClientset(pd)
+	return &Handler{
+		client:   fakeclients.PCIDeviceClient(client.DevicesV1beta1().PCIDevices),
+		sysfs:    fs,
+		nodeName: "node1",
+		openGroup: func(group string) (io.Closer, error) {
+			if group != "13" {
+				t.Fatalf("expected group 13 to be opened, got %s", group)
+			}
+			return nopCloser{}, groupErr
+		},
+	}, fs
+}
+
+func wasReset(t *testing.T, fs *sysfs.SysFS) bool {
+	value, err := os.ReadFile(filepath.Join(fs.DevicePath(addr), "reset"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(value) == "1"
+}
+
+func TestReset(t *testing.T) {
+	tests := []struct {
+		name           string
+		driver         string
+		method         string
+		groupErr       error
+		expectedResult string
+	}{
+		{name: "unbound", expectedResult: v1beta1.PCIDeviceResetSucceeded},
+		{name: "vfio-pci and not in use", driver: "vfio-pci", method: "bus", expectedResult: v1beta1.PCIDeviceResetSucceeded},
+		{name: "vfio-pci and in use", driver: "vfio-pci", groupErr: syscall.EBUSY, expectedResult: v1beta1.PCIDeviceResetRefused},
+		{name: "bound to its driver", driver: "nvidia", expectedResult: v1beta1.PCIDeviceResetRefused},
+		{name: "unsupported method", method: "pm", expectedResult: v1beta1.PCIDeviceResetFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pd := newDevice(tt.driver, tt.method)
+			h, fs := newHandler(t, pd, tt.groupErr)
+			updated, err := h.OnChange(pd.Name, pd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := updated.Status.LastReset
+			if result == nil || result.Result != tt.expectedResult || !result.RequestedAt.Equal(pd.Spec.ResetRequestedAt) {
+				t.Fatalf("expected result %s, got %+v", tt.expectedResult, result)
+			}
+			if reset := wasReset(t, fs); reset != (tt.expectedResult == v1beta1.PCIDeviceResetSucceeded) {
+				t.Fatalf("expected the device to be reset only if it succeeded, reset: %t", reset)
+			}
+			if updated.ResetPending() {
+				t.Fatal("expected the reset to no longer be pending")
+			}
+		})
+	}
+}
+
+func TestResetOnlyOnce(t *testing.T) {
+	pd := newDevice("", "")
+	pd.Status.LastReset = &v1beta1.PCIDeviceResetStatus{RequestedAt: *pd.Spec.ResetRequestedAt, Result: v1beta1.PCIDeviceResetSucceeded}
+	h, fs := newHandler(t, pd, nil)
+	if _, err := h.OnChange(pd.Name, pd); err != nil {
+		t.Fatal(err)
+	}
+	if wasReset(t, fs) {
+		t.Fatal("expected a reset to be carried out only once")
+	}
+}
@@ -0,0 +1,121 @@
+package orphanedclaim
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	ctlcorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+const (
+	// recheckPeriod is how often an orphaned claim checks whether its node came back
+	recheckPeriod = time.Minute * 5
+)
+
+type Handler struct {
+	claimClient  ctl.PCIDeviceClaimClient
+	nodeClient   ctlcorev1.NodeClient
+	enqueue      func(name string)
+	enqueueAfter func(name string, duration time.Duration)
+}
+
+// Register starts the controller that marks PCIDeviceClaims Orphaned when their node is
+// removed from the cluster. Unlike PCIDevices, which are garbage collected with their Node,
+// claims are kept, so that whoever made them can see what happened to them. It should only
+// run in one place in the cluster.
+func Register(
+	ctx context.Context,
+	pdc ctl.PCIDeviceClaimController,
+	nodes ctlcorev1.NodeController,
+) {
+	logrus.Info("Registering Orphaned PCI Device Claims controller")
+	handler := &Handler{
+		claimClient:  pdc,
+		nodeClient:   nodes,
+		enqueue:      pdc.Enqueue,
+		enqueueAfter: pdc.EnqueueAfter,
+	}
+	pdc.OnChange(ctx, "pcideviceclaim-orphaned", handler.OnClaimChange)
+	nodes.OnChange(ctx, "pcideviceclaim-orphaned-node", handler.OnNodeChange)
+}
+
+// OnClaimChange sets the Orphaned condition of a claim whose node doesn't exist,
+// and removes it once the node is back
+func (h *Handler) OnClaimChange(key string, pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	if pdc == nil {
+		return pdc, nil
+	}
+	_, err := h.nodeClient.Get(pdc.Spec.NodeName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return pdc, err
+	}
+	if pdc.DeletionTimestamp != nil {
+		if apierrors.IsNotFound(err) {
+			return h.removeFinalizer(pdc)
+		}
+		return pdc, nil
+	}
+	pdcCopy := pdc.DeepCopy()
+	if apierrors.IsNotFound(err) {
+		meta.SetStatusCondition(&pdcCopy.Status.Conditions, metav1.Condition{
+			Type:    v1beta1.PCIDeviceClaimOrphaned,
+			Status:  metav1.ConditionTrue,
+			Reason:  "NodeNotFound",
+			Message: fmt.Sprintf("node %s no longer exists", pdc.Spec.NodeName),
+		})
+		h.enqueueAfter(pdc.Name, recheckPeriod)
+	} else {
+		meta.RemoveStatusCondition(&pdcCopy.Status.Conditions, v1beta1.PCIDeviceClaimOrphaned)
+	}
+	if equality.Semantic.DeepEqual(pdc.Status, pdcCopy.Status) {
+		return pdc, nil
+	}
+	if meta.IsStatusConditionTrue(pdcCopy.Status.Conditions, v1beta1.PCIDeviceClaimOrphaned) {
+		logrus.Infof("Node %s of PCI Device Claim %s no longer exists, marking it orphaned", pdc.Spec.NodeName, pdc.Name)
+	}
+	return h.claimClient.UpdateStatus(pdcCopy)
+}
+
+// removeFinalizer lets a claim of a removed node be deleted, as there's no agent left to release the device
+func (h *Handler) removeFinalizer(pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	var finalizers []string
+	for _, f := range pdc.Finalizers {
+		if f != v1beta1.PCIDeviceClaimFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if len(finalizers) == len(pdc.Finalizers) {
+		return pdc, nil
+	}
+	logrus.Infof("Node %s of PCI Device Claim %s no longer exists, removing its finalizer", pdc.Spec.NodeName, pdc.Name)
+	pdcCopy := pdc.DeepCopy()
+	pdcCopy.Finalizers = finalizers
+	return h.claimClient.Update(pdcCopy)
+}
+
+// OnNodeChange rechecks the claims of a node when it is removed
+func (h *Handler) OnNodeChange(key string, node *corev1.Node) (*corev1.Node, error) {
+	if node != nil && node.DeletionTimestamp == nil {
+		return node, nil
+	}
+	pdcs, err := h.claimClient.List(metav1.ListOptions{})
+	if err != nil {
+		return node, err
+	}
+	for _, pdc := range pdcs.Items {
+		if pdc.Spec.NodeName == key {
+			h.enqueue(pdc.Name)
+		}
+	}
+	return node, nil
+}
@@ -0,0 +1,109 @@
+package orphanedclaim
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+func newClaim(name string, nodeName string) *v1beta1.PCIDeviceClaim {
+	return &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1beta1.PCIDeviceClaimSpec{NodeName: nodeName, Address: "0000:01:00.0"},
+	}
+}
+
+// newHandler returns a handler for a cluster with only node1, and the names it enqueues
+func newHandler(claims ...runtime.Object) (*Handler, *[]string) {
+	client := fake.NewSimpleClientset(claims...)
+	nodes := k8sfake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})
+	var enqueued []string
+	return &Handler{
+		claimClient:  fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+		nodeClient:   fakeclients.NodeClient(nodes.CoreV1().Nodes),
+		enqueue:      func(name string) { enqueued = append(enqueued, name) },
+		enqueueAfter: func(name string, duration time.Duration) {},
+	}, &enqueued
+}
+
+func TestOnClaimChange(t *testing.T) {
+	gone := newClaim("node2-nic", "node2")
+	back := newClaim("node1-nic", "node1")
+	meta.SetStatusCondition(&back.Status.Conditions, metav1.Condition{
+		Type:   v1beta1.PCIDeviceClaimOrphaned,
+		Status: metav1.ConditionTrue,
+		Reason: "NodeNotFound",
+	})
+	h, _ := newHandler(gone, back)
+
+	updated, err := h.OnClaimChange(gone.Name, gone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(updated.Status.Conditions, v1beta1.PCIDeviceClaimOrphaned) {
+		t.Fatalf("expected %s to be orphaned, got %v", gone.Name, updated.Status.Conditions)
+	}
+	updated, err = h.OnClaimChange(back.Name, back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.FindStatusCondition(updated.Status.Conditions, v1beta1.PCIDeviceClaimOrphaned) != nil {
+		t.Fatalf("expected %s to no longer be orphaned, got %v", back.Name, updated.Status.Conditions)
+	}
+}
+
+func TestOnNodeChange(t *testing.T) {
+	h, enqueued := newHandler(newClaim("node1-nic", "node1"), newClaim("node1-gpu", "node1"), newClaim("node2-nic", "node2"))
+
+	// Node updates are ignored
+	if _, err := h.OnNodeChange("node1", &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*enqueued) != 0 {
+		t.Fatalf("expected no claims to be enqueued, got %v", *enqueued)
+	}
+	// The claims of a removed node are rechecked
+	if _, err := h.OnNodeChange("node1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*enqueued, []string{"node1-gpu", "node1-nic"}) {
+		t.Fatalf("expected the claims of node1 to be enqueued, got %v", *enqueued)
+	}
+}
+
+func TestOnClaimChangeRemovesFinalizerOfRemovedNode(t *testing.T) {
+	now := metav1.Now()
+	gone := newClaim("node2-nic", "node2")
+	back := newClaim("node1-nic", "node1")
+	for _, pdc := range []*v1beta1.PCIDeviceClaim{gone, back} {
+		pdc.DeletionTimestamp = &now
+		pdc.Finalizers = []string{v1beta1.PCIDeviceClaimFinalizer}
+	}
+	h, _ := newHandler(gone, back)
+
+	updated, err := h.OnClaimChange(gone.Name, gone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.Finalizers) != 0 {
+		t.Fatalf("expected the finalizer of %s to be removed, got %v", gone.Name, updated.Finalizers)
+	}
+	// The agent on an existing node releases the device first
+	updated, err = h.OnClaimChange(back.Name, back)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.Finalizers) != 1 {
+		t.Fatalf("expected the finalizer of %s to be kept, got %v", back.Name, updated.Finalizers)
+	}
+}
@@ -2,57 +2,180 @@ package pcidevice
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"time"
 
 	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
 	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/nodelabels"
+	"github.com/harvester/pcidevices/pkg/pciids"
+	"github.com/harvester/pcidevices/pkg/sysfs"
+	"github.com/harvester/pcidevices/pkg/uevent"
+	ctlcorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
 	"github.com/sirupsen/logrus"
 	"github.com/u-root/u-root/pkg/pci"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
+	// resyncPeriod is how often the whole bus is rescanned, to catch uevents that were missed
+	resyncPeriod = time.Minute * 5
+	// reconcilePeriod is how often the whole bus is rescanned if uevents are not available
 	reconcilePeriod = time.Second * 20
+	// absentGracePeriod is how long a claimed PCIDevice is kept after it disappears from the bus
+	absentGracePeriod = time.Minute * 10
+	// relabelDelay is how long after a device is added or removed the node labels are updated,
+	// so that a burst of hotplug events, e.g. from enabling VFs, updates them once
+	relabelDelay = time.Second
 )
 
 type Handler struct {
-	client ctl.PCIDeviceClient
+	client      ctl.PCIDeviceClient
+	claimClient ctl.PCIDeviceClaimClient
+	nodeClient  ctlcorev1.NodeClient
+	nodeCache   ctlcorev1.NodeCache
+	sysfs       *sysfs.SysFS
+	ids         *pciids.DB
+	nodeLabels  nodelabels.Config
+	recorder    record.EventRecorder
+	// hostname is set if it differs from the node name. PCIDevices used to be named after the
+	// hostname, and are moved to the node name.
+	hostname string
 }
 
 func Register(
 	ctx context.Context,
 	pd ctl.PCIDeviceClient,
+	pdc ctl.PCIDeviceClaimClient,
+	nodes ctlcorev1.NodeController,
+	fs *sysfs.SysFS,
+	ids *pciids.DB,
+	source uevent.Source,
+	nodeName string,
+	nodeLabels nodelabels.Config,
+	recorder record.EventRecorder,
 ) error {
 	logrus.Info("Registering PCI Devices controller")
 	handler := &Handler{
-		client: pd,
+		client:      pd,
+		claimClient: pdc,
+		nodeClient:  nodes,
+		nodeCache:   nodes.Cache(),
+		sysfs:       fs,
+		ids:         ids,
+		nodeLabels:  nodeLabels,
+		recorder:    recorder,
 	}
-	hostname, err := os.Hostname()
-	if err != nil {
-		return err
+	if hostname, err := os.Hostname(); err != nil {
+		logrus.Errorf("Failed to get the hostname, not migrating PCI Devices named after it: %v", err)
+	} else if hostname != nodeName {
+		handler.hostname = hostname
 	}
-	// start goroutine to regularly reconcile the PCI Devices list
+	// start goroutine to keep the PCI Devices list in sync with the bus, once the node cache is synced
 	go func() {
-		ticker := time.NewTicker(reconcilePeriod)
-		for range ticker.C {
+		if !cache.WaitForCacheSync(ctx.Done(), nodes.Informer().HasSynced) {
+			return
+		}
+		handler.watch(ctx, nodeName, source)
+	}()
+	return nil
+}
+
+// watch reconciles single PCI Devices as kernel uevents about them come in,
+// and the whole PCI Devices list every resyncPeriod. If uevents are not available,
+// it falls back to reconciling the whole list every reconcilePeriod. The node labels are
+// updated relabelDelay after devices were added or removed, and on every reconciliation.
+func (h Handler) watch(ctx context.Context, nodeName string, source uevent.Source) {
+	period := resyncPeriod
+	events, err := source.Events(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to listen for kernel uevents, polling the PCI bus instead: %v", err)
+		period = reconcilePeriod
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	var relabel <-chan time.Time
+
+	if err := h.migrateHostnamePCIDevices(nodeName); err != nil {
+		logrus.Errorf("Failed to migrate the PCI Devices named after hostname %s: %v", h.hostname, err)
+	}
+	logrus.Info("Reconciling PCI Devices list")
+	if err := h.reconcilePCIDevices(nodeName); err != nil {
+		logrus.Errorf("PCI device reconciliation error: %v", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				logrus.Error("Kernel uevent stream closed, polling the PCI bus instead")
+				events = nil
+				ticker.Reset(reconcilePeriod)
+				continue
+			}
+			if event.Subsystem != uevent.SubsystemPCI {
+				continue
+			}
+			if err := h.handleEvent(event, nodeName); err != nil {
+				logrus.Errorf("Failed to handle %s uevent for PCI device %s: %v", event.Action, event.PCIAddress(), err)
+			}
+			if (event.Action == uevent.ActionAdd || event.Action == uevent.ActionRemove) && relabel == nil {
+				relabel = time.After(relabelDelay)
+			}
+		case <-relabel:
+			relabel = nil
+			pcidevices, err := h.sysfs.Devices()
+			if err == nil {
+				err = h.updateNodeLabels(nodeName, pcidevices)
+			}
+			if err != nil {
+				logrus.Errorf("Failed to update the labels of node %s: %v", nodeName, err)
+			}
+		case <-ticker.C:
 			logrus.Info("Reconciling PCI Devices list")
-			if err := handler.reconcilePCIDevices(hostname); err != nil {
+			if err := h.reconcilePCIDevices(nodeName); err != nil {
 				logrus.Errorf("PCI device reconciliation error: %v", err)
 			}
 		}
-	}()
+	}
+}
+
+func (h Handler) handleEvent(event uevent.Event, nodeName string) error {
+	addr := event.PCIAddress()
+	logrus.Debugf("Received %s uevent for PCI device %s", event.Action, addr)
+	switch event.Action {
+	case uevent.ActionRemove:
+		pcidevices, err := h.sysfs.Devices()
+		if err != nil {
+			return err
+		}
+		var setOfRealPCIAddrs map[string]bool = make(map[string]bool)
+		for _, dev := range pcidevices {
+			setOfRealPCIAddrs[dev.Addr] = true
+		}
+		return h.removeStalePCIDevices(nodeName, setOfRealPCIAddrs)
+	case uevent.ActionAdd, uevent.ActionBind, uevent.ActionUnbind, uevent.ActionChange:
+		dev, err := h.sysfs.Device(addr)
+		if err != nil {
+			return err
+		}
+		return h.reconcilePCIDevice(dev, nodeName)
+	}
 	return nil
 }
 
-func (h Handler) reconcilePCIDevices(hostname string) error {
+func (h Handler) reconcilePCIDevices(nodeName string) error {
 	// List all PCI Devices on host
-	busReader, err := pci.NewBusReader()
-	if err != nil {
-		return err
-	}
 	var pcidevices []*pci.PCI
-	pcidevices, err = busReader.Read()
+	pcidevices, err := h.sysfs.Devices()
 	if err != nil {
 		return err
 	}
@@ -60,51 +183,234 @@ func (h Handler) reconcilePCIDevices(hostname string) error {
 	var setOfRealPCIAddrs map[string]bool = make(map[string]bool)
 	for _, dev := range pcidevices {
 		setOfRealPCIAddrs[dev.Addr] = true
-		name := v1beta1.PCIDeviceNameForHostname(dev, hostname)
-		// Check if device is stored
-		_, err := h.client.Get(name, metav1.GetOptions{})
-
-		if err != nil {
-			logrus.Errorf("Failed to get %s: %s\n", name, err)
+		if err := h.reconcilePCIDevice(dev, nodeName); err != nil {
+			logrus.Errorf("Failed to reconcile PCI Device %s: %s", dev.Addr, err)
+		}
+	}
+	if err := h.updateNodeLabels(nodeName, pcidevices); err != nil {
+		logrus.Errorf("Failed to update the labels of node %s: %v", nodeName, err)
+	}
+	return h.removeStalePCIDevices(nodeName, setOfRealPCIAddrs)
+}
 
-			// Create the PCIDevice CR if it doesn't exist
-			var pdToCreate v1beta1.PCIDevice = v1beta1.NewPCIDeviceForHostname(dev, hostname)
-			logrus.Infof("Creating PCI Device: %s\n", err)
-			_, err := h.client.Create(&pdToCreate)
+// migrateHostnamePCIDevices moves the PCIDevices that were named after the hostname, from before
+// they were named after the node name, to the node name. The labels and annotations of the devices
+// on the bus are kept, the others are deleted like stale PCIDevices.
+func (h Handler) migrateHostnamePCIDevices(nodeName string) error {
+	if h.hostname == "" {
+		return nil
+	}
+	pciDeviceCRs, err := h.client.List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, oldCR := range pciDeviceCRs.Items {
+		if oldCR.Status.NodeName != h.hostname {
+			continue
+		}
+		if dev, err := h.sysfs.Device(v1beta1.CanonicalPCIAddress(oldCR.Status.Address)); err == nil {
+			name := v1beta1.PCIDeviceNameForHostname(dev, nodeName)
+			_, err := h.client.Get(name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				pdToCreate := v1beta1.NewPCIDeviceForHostname(dev, nodeName)
+				pdToCreate.Labels = oldCR.Labels
+				pdToCreate.Annotations = oldCR.Annotations
+				logrus.Infof("Creating PCI Device %s, which was named %s after the hostname", name, oldCR.Name)
+				_, err = h.client.Create(&pdToCreate)
+			}
 			if err != nil {
-				logrus.Errorf("Failed to create PCI Device: %s\n", err)
+				logrus.Errorf("Failed to migrate PCI Device %s to %s: %s", oldCR.Name, name, err)
+				continue
 			}
 		}
-		// Update the stored device
-		devCR, err := h.client.Get(name, metav1.GetOptions{})
-		if err != nil {
-			logrus.Errorf("Failed to get %s: %s\n", name, err)
+		logrus.Infof("Deleting PCI Device %s, which is named after the hostname", oldCR.Name)
+		if err := h.client.Delete(oldCR.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			logrus.Errorf("Failed deleting PCI Device %s: %s", oldCR.Name, err)
 		}
-		devCR.Status.Update(dev, hostname) // update the in-memory CR with the current PCI info
-		_, err = h.client.Update(devCR)
+	}
+	return nil
+}
+
+// updateNodeLabels summarizes the devices on the bus in the labels and annotations of the node
+func (h Handler) updateNodeLabels(nodeName string, pcidevices []*pci.PCI) error {
+	node, err := h.nodeCache.Get(nodeName)
+	if err != nil {
+		return err
+	}
+	summary, err := nodelabels.DevicesSummary(pcidevices)
+	if err != nil {
+		return err
+	}
+	node = node.DeepCopy()
+	if !nodelabels.Update(node, h.nodeLabels.Labels(pcidevices), summary) {
+		return nil
+	}
+	logrus.Infof("Updating the PCI device labels of node %s", nodeName)
+	_, err = h.nodeClient.Update(node)
+	return err
+}
+
+// reconcilePCIDevice creates the PCIDevice for dev if it doesn't exist yet,
+// and updates its status with the current PCI info
+func (h Handler) reconcilePCIDevice(dev *pci.PCI, nodeName string) error {
+	name := v1beta1.PCIDeviceNameForHostname(dev, nodeName)
+	// Check if device is stored
+	devCR, err := h.client.Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// Create the PCIDevice CR if it doesn't exist
+		var pdToCreate v1beta1.PCIDevice = v1beta1.NewPCIDeviceForHostname(dev, nodeName)
+		legacyCR, legacyErr := h.client.Get(v1beta1.LegacyPCIDeviceNameForHostname(dev, nodeName), metav1.GetOptions{})
+		if legacyErr == nil {
+			// Keep the labels and annotations users put on the legacy PCIDevice
+			pdToCreate.Labels = legacyCR.Labels
+			pdToCreate.Annotations = legacyCR.Annotations
+		}
+		logrus.Infof("Creating PCI Device: %s", name)
+		devCR, err = h.client.Create(&pdToCreate)
+		if err == nil {
+			h.recorder.Eventf(devCR, corev1.EventTypeNormal, "Discovered", "Discovered PCI device %s on node %s", dev.Addr, nodeName)
+		}
+		if err == nil && legacyErr == nil {
+			logrus.Infof("Deleting PCI Device %s, which was renamed to %s", legacyCR.Name, name)
+			if err := h.client.Delete(legacyCR.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				logrus.Errorf("Failed deleting PCI Device %s: %s", legacyCR.Name, err)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// Update the stored device, only writing it if the PCI info changed
+	oldStatus := devCR.Status.DeepCopy()
+	devCR.Status.Update(dev, nodeName, h.sysfs, h.ids) // update the in-memory CR with the current PCI info
+	if !equality.Semantic.DeepEqual(*oldStatus, devCR.Status) {
+		devCR, err = h.client.UpdateStatus(devCR)
 		if err != nil {
-			logrus.Errorf("Failed to update %v: %s\n", devCR.Status.Address, err)
+			return err
 		}
-		_, err = h.client.UpdateStatus(devCR)
+	}
+	metadataChanged := devCR.UpdateLabels()
+	nodeOwnerAdded, err := h.setNodeOwner(devCR, nodeName)
+	if err != nil {
+		return err
+	}
+	metadataChanged = metadataChanged || nodeOwnerAdded
+	if devCR.Status.SRIOV.IsVF() {
+		ownerAdded, err := h.setPhysFnOwner(devCR, nodeName)
 		if err != nil {
-			logrus.Errorf("(Resource exists) Failed to update status sub-resource: %s\n", err)
-		}
-	}
-	//pciDeviceCRs, err := h.client.List(metav1.ListOptions{})
-	//if err != nil {
-	//	logrus.Errorf("Failed to list PCI Device CRs")
-	//}
-	// TODO: Properly handle PCI device unplug. Since this is uncommon, not prioritizing this now
-	//for _, devCR := range pciDeviceCRs.Items {
-	//	val, found := setOfRealPCIAddrs[devCR.Status.Address]
-	//	if !found || !val {
-	//		logrus.Infof("Deleting PCI Device: %s", devCR.Name)
-	//		err = h.client.Delete(devCR.Name, &metav1.DeleteOptions{})
-	//		if err != nil {
-	//			logrus.Errorf("Failed deleting PCI Device %s: %s", devCR.Name, err)
-	//		}
-	//	}
-	//}
+			return err
+		}
+		metadataChanged = metadataChanged || ownerAdded
+	}
+	if metadataChanged {
+		_, err = h.client.Update(devCR)
+	}
+	return err
+}
+
+// setPhysFnOwner adds an owner reference from the PCIDevice of a VF to the PCIDevice of its PF,
+// so that the VF can be traced back to its PF. It returns true if the reference was added.
+func (h Handler) setPhysFnOwner(devCR *v1beta1.PCIDevice, nodeName string) (bool, error) {
+	pf, err := h.sysfs.Device(devCR.Status.SRIOV.PhysFn)
+	if err != nil {
+		return false, err
+	}
+	pfCR, err := h.client.Get(v1beta1.PCIDeviceNameForHostname(pf, nodeName), metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get PF of %s: %w", devCR.Name, err)
+	}
+	return addOwnerReference(devCR, metav1.OwnerReference{
+		APIVersion: v1beta1.SchemeGroupVersion.String(),
+		Kind:       "PCIDevice",
+		Name:       pfCR.Name,
+		UID:        pfCR.UID,
+	}), nil
+}
+
+// setNodeOwner adds an owner reference from the PCIDevice to its Node, so that it is garbage
+// collected when the node is removed from the cluster. It returns true if the reference was added.
+func (h Handler) setNodeOwner(devCR *v1beta1.PCIDevice, nodeName string) (bool, error) {
+	for _, ref := range devCR.OwnerReferences {
+		if ref.Kind == "Node" && ref.Name == nodeName {
+			return false, nil
+		}
+	}
+	node, err := h.nodeCache.Get(nodeName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get node of %s: %w", devCR.Name, err)
+	}
+	return addOwnerReference(devCR, metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Node",
+		Name:       node.Name,
+		UID:        node.UID,
+	}), nil
+}
+
+// addOwnerReference adds ref to the owner references of devCR, unless it's already there
+func addOwnerReference(devCR *v1beta1.PCIDevice, ref metav1.OwnerReference) bool {
+	for _, existing := range devCR.OwnerReferences {
+		if existing.UID == ref.UID {
+			return false
+		}
+	}
+	devCR.OwnerReferences = append(devCR.OwnerReferences, ref)
+	return true
+}
+
+// removeStalePCIDevices deletes the PCIDevices of this node whose address is no longer on the bus.
+// If a PCIDeviceClaim still references the device, it is marked Absent instead, and only deleted
+// once it has been absent for longer than absentGracePeriod.
+func (h Handler) removeStalePCIDevices(nodeName string, setOfRealPCIAddrs map[string]bool) error {
+	pciDeviceCRs, err := h.client.List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	pdcs, err := h.claimClient.List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var claimedAddrs map[string]bool = make(map[string]bool)
+	for _, pdc := range pdcs.Items {
+		if pdc.Spec.NodeName == nodeName {
+			claimedAddrs[v1beta1.CanonicalPCIAddress(pdc.Spec.Address)] = true
+		}
+	}
+
+	for _, devCR := range pciDeviceCRs.Items {
+		// Only touch the PCIDevices of this node
+		if devCR.Status.NodeName != nodeName || setOfRealPCIAddrs[devCR.Status.Address] {
+			continue
+		}
+		if claimedAddrs[devCR.Status.Address] {
+			absent := meta.FindStatusCondition(devCR.Status.Conditions, v1beta1.PCIDeviceAbsent)
+			if absent == nil {
+				logrus.Infof("PCI Device %s is no longer on the bus, but is still claimed, marking it absent", devCR.Name)
+				meta.SetStatusCondition(&devCR.Status.Conditions, metav1.Condition{
+					Type:    v1beta1.PCIDeviceAbsent,
+					Status:  metav1.ConditionTrue,
+					Reason:  "DeviceRemoved",
+					Message: fmt.Sprintf("device %s is no longer on the bus of node %s", devCR.Status.Address, nodeName),
+				})
+				if _, err := h.client.UpdateStatus(&devCR); err != nil {
+					logrus.Errorf("Failed to mark PCI Device %s absent: %s", devCR.Name, err)
+				}
+				h.recorder.Eventf(&devCR, corev1.EventTypeWarning, "Absent",
+					"PCI device %s is no longer on the bus, it is kept while it is claimed", devCR.Status.Address)
+				continue
+			}
+			if time.Since(absent.LastTransitionTime.Time) < absentGracePeriod {
+				continue
+			}
+		}
+		logrus.Infof("Deleting PCI Device: %s", devCR.Name)
+		err = h.client.Delete(devCR.Name, &metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			logrus.Errorf("Failed deleting PCI Device %s: %s", devCR.Name, err)
+			continue
+		}
+		h.recorder.Eventf(&devCR, corev1.EventTypeNormal, "Removed", "PCI device %s was removed from node %s", devCR.Status.Address, nodeName)
+	}
 
 	return nil
 }
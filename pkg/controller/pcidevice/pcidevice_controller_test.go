@@ -0,0 +1,415 @@
+package pcidevice
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/nodelabels"
+	"github.com/harvester/pcidevices/pkg/sysfs"
+	"github.com/harvester/pcidevices/pkg/sysfs/sysfstest"
+	"github.com/harvester/pcidevices/pkg/uevent"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+// fakeSource feeds synthetic uevents to the controller
+type fakeSource chan uevent.Event
+
+func (s fakeSource) Events(ctx context.Context) (<-chan uevent.Event, error) {
+	return s, nil
+}
+
+// newNodeClients returns a client and a cache of node1
+func newNodeClients() (fakeclients.NodeClient, fakeclients.NodeCache) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "node1-uid"}}
+	client := k8sfake.NewSimpleClientset(node)
+	return fakeclients.NodeClient(client.CoreV1().Nodes), fakeclients.NodeCache(client.CoreV1().Nodes)
+}
+
+func newPCIDevice(name string, nodeName string, addr string) *v1beta1.PCIDevice {
+	return &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:  addr,
+			NodeName: nodeName,
+		},
+	}
+}
+
+func TestRemoveStalePCIDevices(t *testing.T) {
+	longGone := newPCIDevice("node1-longgone", "node1", "0000:05:00.0")
+	longGone.Status.Conditions = []metav1.Condition{{
+		Type:               v1beta1.PCIDeviceAbsent,
+		Status:             metav1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-2 * absentGracePeriod)),
+	}}
+	client := fake.NewSimpleClientset(
+		newPCIDevice("node1-present", "node1", "0000:01:00.0"),
+		newPCIDevice("node1-unplugged", "node1", "0000:02:00.0"),
+		newPCIDevice("node1-claimed", "node1", "0000:03:00.0"),
+		newPCIDevice("node2-other", "node2", "0000:04:00.0"),
+		longGone,
+		&v1beta1.PCIDeviceClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1-claim"},
+			Spec:       v1beta1.PCIDeviceClaimSpec{NodeName: "node1", Address: "0000:03:00.0"},
+		},
+		&v1beta1.PCIDeviceClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1-claim-longgone"},
+			Spec:       v1beta1.PCIDeviceClaimSpec{NodeName: "node1", Address: "0000:05:00.0"},
+		},
+	)
+	pdClient := fakeclients.PCIDeviceClient(client.DevicesV1beta1().PCIDevices)
+	recorder := record.NewFakeRecorder(10)
+	h := Handler{
+		client:      pdClient,
+		claimClient: fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+		recorder:    recorder,
+	}
+
+	err := h.removeStalePCIDevices("node1", map[string]bool{"0000:01:00.0": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"node1-present", "node2-other", "node1-claimed"} {
+		if _, err := pdClient.Get(name, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected %s to be kept, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"node1-unplugged", "node1-longgone"} {
+		if _, err := pdClient.Get(name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("expected %s to be deleted, got %v", name, err)
+		}
+	}
+	claimed, err := pdClient.Get("node1-claimed", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(claimed.Status.Conditions, v1beta1.PCIDeviceAbsent) {
+		t.Errorf("expected node1-claimed to be marked absent, got %v", claimed.Status.Conditions)
+	}
+	close(recorder.Events)
+	var events []string
+	for event := range recorder.Events {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	expectedEvents := []string{
+		"Normal Removed PCI device 0000:02:00.0 was removed from node node1",
+		"Normal Removed PCI device 0000:05:00.0 was removed from node node1",
+		"Warning Absent PCI device 0000:03:00.0 is no longer on the bus, it is kept while it is claimed",
+	}
+	if !reflect.DeepEqual(events, expectedEvents) {
+		t.Errorf("expected events %q, got %q", expectedEvents, events)
+	}
+}
+
+func TestWatchHandlesUevents(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{Addr: "0000:01:00.0", Vendor: 0x8086, Device: 0x1521, Driver: "igb"})
+	client := fake.NewSimpleClientset()
+	pdClient := fakeclients.PCIDeviceClient(client.DevicesV1beta1().PCIDevices)
+	nodeClient, nodeCache := newNodeClients()
+	h := Handler{
+		client:      pdClient,
+		claimClient: fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+		recorder:    &record.FakeRecorder{},
+		nodeClient:  nodeClient,
+		nodeCache:   nodeCache,
+		sysfs:       sysfs.New(tree.Root, tree.WriteModulesAlias()),
+	}
+	source := make(fakeSource)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.watch(ctx, "node1", source)
+
+	// Hotplug a second device, then rebind it to vfio-pci
+	newDev := sysfstest.Device{Addr: "0000:02:00.0", Vendor: 0x10de, Device: 0x1eb8, Driver: "nvidia"}
+	tree.AddDevice(newDev)
+	source <- uevent.Event{Action: uevent.ActionAdd, Subsystem: uevent.SubsystemPCI, Env: map[string]string{"PCI_SLOT_NAME": newDev.Addr}}
+	tree.Bind(newDev.Addr, "vfio-pci")
+	source <- uevent.Event{Action: uevent.ActionBind, Subsystem: uevent.SubsystemPCI, Env: map[string]string{"PCI_SLOT_NAME": newDev.Addr}}
+	// Events from other subsystems are ignored
+	source <- uevent.Event{Action: uevent.ActionAdd, Subsystem: "usb", DevPath: "/devices/usb1/1-1"}
+
+	waitFor(t, func() bool {
+		pds, err := pdClient.List(metav1.ListOptions{})
+		if err != nil || len(pds.Items) != 2 {
+			return false
+		}
+		for _, pd := range pds.Items {
+			if pd.Status.Address == newDev.Addr {
+				return pd.Status.KernelDriverInUse == "vfio-pci"
+			}
+		}
+		return false
+	})
+
+	// The node labels are updated after the hotplug
+	waitForDevicesAnnotation(t, nodeCache, `{"10de:1eb8":1,"8086:1521":1}`)
+
+	// Unplug the first device
+	tree.RemoveDevice("0000:01:00.0")
+	source <- uevent.Event{Action: uevent.ActionRemove, Subsystem: uevent.SubsystemPCI, Env: map[string]string{"PCI_SLOT_NAME": "0000:01:00.0"}}
+	waitFor(t, func() bool {
+		pds, err := pdClient.List(metav1.ListOptions{})
+		return err == nil && len(pds.Items) == 1 && pds.Items[0].Status.Address == newDev.Addr
+	})
+	waitForDevicesAnnotation(t, nodeCache, `{"10de:1eb8":1}`)
+}
+
+func waitForDevicesAnnotation(t *testing.T, nodeCache fakeclients.NodeCache, expected string) {
+	t.Helper()
+	waitFor(t, func() bool {
+		node, err := nodeCache.Get("node1")
+		return err == nil && node.Annotations[nodelabels.DevicesAnnotation] == expected
+	})
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconcileLinksVFToPF(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	pf := sysfstest.Device{Addr: "0000:22:00.0", Vendor: 0x8086, Device: 0x1557, Driver: "ixgbe"}
+	vf := sysfstest.Device{Addr: "0000:22:10.0", Vendor: 0x8086, Device: 0x10ed, Driver: "ixgbevf"}
+	tree.AddDevice(pf)
+	tree.AddVFs(pf, 63, vf)
+	fs := sysfs.New(tree.Root, tree.WriteModulesAlias())
+	pfDev, err := fs.Device(pf.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfCR := v1beta1.NewPCIDeviceForHostname(pfDev, "node1")
+	pfCR.UID = "pf-uid"
+	client := fake.NewSimpleClientset(&pfCR)
+	pdClient := fakeclients.PCIDeviceClient(client.DevicesV1beta1().PCIDevices)
+	nodeClient, nodeCache := newNodeClients()
+	h := Handler{
+		client:      pdClient,
+		claimClient: fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+		recorder:    &record.FakeRecorder{},
+		nodeClient:  nodeClient,
+		nodeCache:   nodeCache,
+		sysfs:       fs,
+	}
+
+	if err := h.reconcilePCIDevices("node1"); err != nil {
+		t.Fatal(err)
+	}
+	vfDev, err := fs.Device(vf.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vfCR, err := pdClient.Get(v1beta1.PCIDeviceNameForHostname(vfDev, "node1"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vfCR.OwnerReferences) != 2 || vfCR.OwnerReferences[1].Name != pfCR.Name {
+		t.Fatalf("expected VF to be owned by node1 and %s, got %v", pfCR.Name, vfCR.OwnerReferences)
+	}
+	if vfCR.Status.SRIOV == nil || vfCR.Status.SRIOV.PhysFn != pf.Addr {
+		t.Fatalf("expected VF status to point at PF %s, got %+v", pf.Addr, vfCR.Status.SRIOV)
+	}
+	pfCR2, err := pdClient.Get(pfCR.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pfCR2.Status.SRIOV.IsPF() || pfCR2.Status.SRIOV.NumVFs != 1 || pfCR2.Status.SRIOV.VirtFns[0] != vf.Addr {
+		t.Fatalf("unexpected PF status %+v", pfCR2.Status.SRIOV)
+	}
+}
+
+func TestReconcileRenamesLegacyPCIDevice(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	nic := sysfstest.Device{Addr: "0000:01:00.0", Vendor: 0x8086, Device: 0x1521, Driver: "igb"}
+	tree.AddDevice(nic)
+	fs := sysfs.New(tree.Root, tree.WriteModulesAlias())
+	dev, err := fs.Device(nic.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := newPCIDevice(v1beta1.LegacyPCIDeviceNameForHostname(dev, "node1"), "node1", nic.Addr)
+	legacy.Labels = map[string]string{"app": "nfv"}
+	client := fake.NewSimpleClientset(legacy)
+	pdClient := fakeclients.PCIDeviceClient(client.DevicesV1beta1().PCIDevices)
+	nodeClient, nodeCache := newNodeClients()
+	h := Handler{
+		client:      pdClient,
+		claimClient: fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+		recorder:    &record.FakeRecorder{},
+		nodeClient:  nodeClient,
+		nodeCache:   nodeCache,
+		sysfs:       fs,
+	}
+
+	if err := h.reconcilePCIDevices("node1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pdClient.Get(legacy.Name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("expected %s to be deleted, got %v", legacy.Name, err)
+	}
+	renamed, err := pdClient.Get(v1beta1.PCIDeviceNameForHostname(dev, "node1"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Labels["app"] != "nfv" {
+		t.Fatalf("expected labels to be kept, got %v", renamed.Labels)
+	}
+}
+
+func TestMigrateHostnamePCIDevices(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	nic := sysfstest.Device{Addr: "0000:01:00.0", Vendor: 0x8086, Device: 0x1521, Driver: "igb"}
+	tree.AddDevice(nic)
+	fs := sysfs.New(tree.Root, tree.WriteModulesAlias())
+	dev, err := fs.Device(nic.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The PCIDevices were named after the hostname, which differs from the node name
+	present := newPCIDevice(v1beta1.LegacyPCIDeviceNameForHostname(dev, "host1"), "host1", nic.Addr)
+	present.Labels = map[string]string{"app": "nfv"}
+	client := fake.NewSimpleClientset(
+		present,
+		newPCIDevice("host1-unplugged", "host1", "0000:02:00.0"),
+		newPCIDevice("node2-other", "node2", "0000:01:00.0"),
+	)
+	pdClient := fakeclients.PCIDeviceClient(client.DevicesV1beta1().PCIDevices)
+	h := Handler{
+		client:   pdClient,
+		recorder: &record.FakeRecorder{},
+		sysfs:    fs,
+		hostname: "host1",
+	}
+
+	if err := h.migrateHostnamePCIDevices("node1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{present.Name, "host1-unplugged"} {
+		if _, err := pdClient.Get(name, metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+			t.Errorf("expected %s to be deleted, got %v", name, err)
+		}
+	}
+	if _, err := pdClient.Get("node2-other", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the PCIDevice of another node to be kept, got %v", err)
+	}
+	migrated, err := pdClient.Get(v1beta1.PCIDeviceNameForHostname(dev, "node1"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Status.NodeName != "node1" || migrated.Labels["app"] != "nfv" {
+		t.Fatalf("expected the PCIDevice to move to node1 with its labels, got %+v", migrated)
+	}
+}
+
+func TestReconcileSetsNodeOwner(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{Addr: "0000:01:00.0", Vendor: 0x8086, Device: 0x1521, Driver: "igb"})
+	client := fake.NewSimpleClientset()
+	pdClient := fakeclients.PCIDeviceClient(client.DevicesV1beta1().PCIDevices)
+	nodeClient, nodeCache := newNodeClients()
+	h := Handler{
+		client:      pdClient,
+		claimClient: fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+		recorder:    &record.FakeRecorder{},
+		nodeClient:  nodeClient,
+		nodeCache:   nodeCache,
+		sysfs:       sysfs.New(tree.Root, tree.WriteModulesAlias()),
+	}
+
+	// Reconciling twice doesn't add the reference twice
+	for i := 0; i < 2; i++ {
+		if err := h.reconcilePCIDevices("node1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pds, err := pdClient.List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pds.Items) != 1 {
+		t.Fatalf("expected 1 PCIDevice, got %d", len(pds.Items))
+	}
+	expected := []metav1.OwnerReference{{APIVersion: "v1", Kind: "Node", Name: "node1", UID: "node1-uid"}}
+	if !reflect.DeepEqual(pds.Items[0].OwnerReferences, expected) {
+		t.Fatalf("expected owner references %v, got %v", expected, pds.Items[0].OwnerReferences)
+	}
+}
+
+func TestReconcileSkipsUnchangedDevices(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{Addr: "0000:01:00.0", Vendor: 0x8086, Device: 0x1521, Driver: "igb"})
+	client := fake.NewSimpleClientset()
+	nodeClient, nodeCache := newNodeClients()
+	h := Handler{
+		client:      fakeclients.PCIDeviceClient(client.DevicesV1beta1().PCIDevices),
+		claimClient: fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+		recorder:    &record.FakeRecorder{},
+		nodeClient:  nodeClient,
+		nodeCache:   nodeCache,
+		sysfs:       sysfs.New(tree.Root, tree.WriteModulesAlias()),
+	}
+
+	if err := h.reconcilePCIDevices("node1"); err != nil {
+		t.Fatal(err)
+	}
+	client.ClearActions()
+	if err := h.reconcilePCIDevices("node1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" {
+			t.Errorf("expected no writes for an unchanged device, got %s %s/%s", action.GetVerb(), action.GetResource().Resource, action.GetSubresource())
+		}
+	}
+}
+
+func TestReconcileUpdatesNodeLabels(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{Addr: "0000:01:00.0", Vendor: 0x10de, Device: 0x1eb8, Class: 0x030200, Driver: "nvidia"})
+	client := fake.NewSimpleClientset()
+	nodeClient, nodeCache := newNodeClients()
+	h := Handler{
+		client:      fakeclients.PCIDeviceClient(client.DevicesV1beta1().PCIDevices),
+		claimClient: fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+		recorder:    &record.FakeRecorder{},
+		nodeClient:  nodeClient,
+		nodeCache:   nodeCache,
+		sysfs:       sysfs.New(tree.Root, tree.WriteModulesAlias()),
+		nodeLabels:  nodelabels.Config{GPU: true, Devices: []string{"10de:1eb8"}},
+	}
+
+	if err := h.reconcilePCIDevices("node1"); err != nil {
+		t.Fatal(err)
+	}
+	node, err := nodeClient.Get("node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Labels[nodelabels.GPUPresentLabel] != "true" || node.Labels["pcidevices.harvesterhci.io/device-10de-1eb8.count"] != "1" {
+		t.Fatalf("unexpected node labels %v", node.Labels)
+	}
+	if node.Annotations[nodelabels.DevicesAnnotation] != `{"10de:1eb8":1}` {
+		t.Fatalf("unexpected node annotations %v", node.Annotations)
+	}
+}
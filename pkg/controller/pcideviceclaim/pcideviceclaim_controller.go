@@ -2,235 +2,444 @@ package pcideviceclaim
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
-	"time"
+	"syscall"
 
-	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	v1beta1gen "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/sysfs"
 )
 
 const (
-	reconcilePeriod = time.Second * 20
+	vfioDriver = "vfio-pci"
+	// claimByNodeAddrIndex indexes the claims by NodeAddr
+	claimByNodeAddrIndex = "pcidevices.harvesterhci.io/claim-by-node-addr"
+	// deviceByNodeAddrIndex indexes the PCIDevices by node and canonical PCI address, like NodeAddr of their claims
+	deviceByNodeAddrIndex = "pcidevices.harvesterhci.io/device-by-node-addr"
 )
 
-type Controller struct {
-	PCIDeviceClaims v1beta1gen.PCIDeviceClaimController
-}
-
 type Handler struct {
 	pdcClient v1beta1gen.PCIDeviceClaimClient
-	pdClient  v1beta1gen.PCIDeviceClient
+	pdcCache  v1beta1gen.PCIDeviceClaimCache
+	pdCache   v1beta1gen.PCIDeviceCache
+	sysfs     *sysfs.SysFS
+	nodeName  string
+	recorder  record.EventRecorder
+	// loadModule loads a kernel module, or the modules matching a modalias
+	loadModule func(name string) error
 }
 
+// Register starts the controller that binds the devices claimed on the node to vfio-pci, and releases
+// them when their claim is deleted. Failures are retried with the work queue's rate limiting.
 func Register(
 	ctx context.Context,
-	pdcClient v1beta1gen.PCIDeviceClaimController,
+	pdc v1beta1gen.PCIDeviceClaimController,
 	pd v1beta1gen.PCIDeviceController,
+	fs *sysfs.SysFS,
+	nodeName string,
+	recorder record.EventRecorder,
 ) error {
 	logrus.Info("Registering PCI Device Claims controller")
+	addIndexers(pdc.Cache(), pd.Cache())
 	handler := &Handler{
-		pdcClient: pdcClient,
-		pdClient:  pd,
+		pdcClient:  pdc,
+		pdcCache:   pdc.Cache(),
+		pdCache:    pd.Cache(),
+		sysfs:      fs,
+		nodeName:   nodeName,
+		recorder:   recorder,
+		loadModule: loadDriver,
 	}
-	hostname, err := os.Hostname()
+	handler.removeVfioDynamicIDs()
+	pdc.OnChange(ctx, "pcideviceclaim-bind", handler.OnChange)
+	pd.OnChange(ctx, "pcidevice-unclaimed-vfio", handler.OnDeviceChange)
+	return nil
+}
+
+// addIndexers adds the indexers that look up the claim of a device and the device of a claim
+func addIndexers(pdcCache v1beta1gen.PCIDeviceClaimCache, pdCache v1beta1gen.PCIDeviceCache) {
+	pdcCache.AddIndexer(claimByNodeAddrIndex, func(pdc *v1beta1.PCIDeviceClaim) ([]string, error) {
+		return []string{pdc.Spec.NodeAddr()}, nil
+	})
+	pdCache.AddIndexer(deviceByNodeAddrIndex, func(pd *v1beta1.PCIDevice) ([]string, error) {
+		return []string{deviceNodeAddr(pd)}, nil
+	})
+}
+
+func deviceNodeAddr(pd *v1beta1.PCIDevice) string {
+	return fmt.Sprintf("%s-%s", pd.Status.NodeName, v1beta1.CanonicalPCIAddress(pd.Status.Address))
+}
+
+func loadDriver(driver string) error {
+	output, err := exec.Command("modprobe", driver).CombinedOutput()
 	if err != nil {
-		return err
+		return fmt.Errorf("modprobe %s: %w: %s", driver, err, strings.TrimSpace(string(output)))
 	}
-	// start goroutine to regularly reconcile the PCI Device Claims' status with their spec
-	go func() {
-		ticker := time.NewTicker(reconcilePeriod)
-		for range ticker.C {
-			logrus.Info("Reconciling PCI Device Claims list")
-			if err := handler.reconcilePCIDeviceClaims(hostname); err != nil {
-				logrus.Errorf("PCI Device Claim reconciliation error: %v", err)
-			}
-		}
-	}()
 	return nil
 }
 
-func driverIsLoaded(driver string) bool {
-	cmd := exec.Command("lsmod")
-	output, err := cmd.Output()
-	if err != nil {
-		fmt.Println(err)
+// loadVfioDrivers loads the vfio kernel modules, if they aren't loaded yet
+func (h *Handler) loadVfioDrivers() {
+	if !h.sysfs.DriverLoaded(vfioDriver) {
+		if err := h.loadModule(vfioDriver); err != nil {
+			logrus.Error(err)
+		}
 	}
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, driver) {
-			return true
+	// vfio_iommu_type1 isn't a PCI driver, it only shows up as a module
+	if !h.sysfs.ModuleLoaded("vfio_iommu_type1") {
+		if err := h.loadModule("vfio_iommu_type1"); err != nil {
+			logrus.Error(err)
 		}
 	}
-	return false
 }
 
-func loadDriver(driver string) {
-	cmd := exec.Command("modprobe", driver)
-	err := cmd.Run()
+// removeVfioDynamicIDs removes the device IDs that earlier versions added to vfio-pci through new_id,
+// which made vfio-pci take every device of a claimed model. IDs from the ids parameter of the
+// module are kept, as they were configured by the administrator.
+func (h *Handler) removeVfioDynamicIDs() {
+	if !h.sysfs.DriverLoaded(vfioDriver) {
+		return
+	}
+	configured := map[string]bool{}
+	if ids, err := h.sysfs.ModuleParameter("vfio_pci", "ids"); err == nil {
+		for _, id := range strings.Split(ids, ",") {
+			// Each entry is vendor:device[:subvendor[:subdevice[:class[:class_mask]]]]
+			if fields := strings.Split(id, ":"); len(fields) >= 2 {
+				configured[strings.ToLower(fields[0]+":"+fields[1])] = true
+			}
+		}
+	}
+	devices, err := h.sysfs.Devices()
 	if err != nil {
-		logrus.Error(err)
+		logrus.Errorf("Error listing PCI devices to remove vfio-pci dynamic IDs: %v", err)
+		return
 	}
-}
-
-func loadVfioDrivers() {
-	for _, driver := range []string{"vfio-pci", "vfio_iommu_type1"} {
-		if !driverIsLoaded(driver) {
-			loadDriver(driver)
+	done := map[string]bool{}
+	for _, dev := range devices {
+		id := fmt.Sprintf("%04x:%04x", dev.Vendor, dev.Device)
+		if done[id] || configured[id] {
+			continue
+		}
+		done[id] = true
+		err := h.sysfs.RemoveDriverID(vfioDriver, dev.Vendor, dev.Device)
+		switch {
+		case err == nil:
+			logrus.Infof("Removed dynamic ID %s from vfio-pci", id)
+		case !errors.Is(err, syscall.ENODEV):
+			logrus.Warnf("Error removing dynamic ID %s from vfio-pci: %v", id, err)
 		}
 	}
 }
 
-func addNewIdToVfioPCIDriver(vendorId int, deviceId int) error {
-	var id string = fmt.Sprintf("%x %x", vendorId, deviceId)
+// OnChange binds the device of a claim of the node to vfio-pci, or releases it if the claim is
+// being deleted. The finalizer makes sure the device is released before the claim is gone.
+func (h *Handler) OnChange(key string, pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	if pdc == nil || pdc.Spec.NodeName != h.nodeName {
+		return pdc, nil
+	}
+	if pdc.DeletionTimestamp != nil {
+		return h.release(pdc)
+	}
+	if !hasFinalizer(pdc) {
+		pdcCopy := pdc.DeepCopy()
+		pdcCopy.Finalizers = append(pdcCopy.Finalizers, v1beta1.PCIDeviceClaimFinalizer)
+		return h.pdcClient.Update(pdcCopy)
+	}
+	return h.bind(pdc)
+}
 
-	file, err := os.OpenFile("/sys/bus/pci/drivers/vfio-pci/new_id", os.O_WRONLY, 0400)
+func (h *Handler) bind(pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	addr, err := pdc.Spec.PCIAddress()
 	if err != nil {
-		return err
+		// Retrying won't help until the spec is fixed
+		logrus.Errorf("PCI Device Claim %s has an invalid address: %v", pdc.Name, err)
+		updated, _ := h.reportError(pdc, v1beta1.PCIDeviceClaimPhaseFailed, v1beta1.PCIDeviceClaimBound, "InvalidAddress", err)
+		return updated, nil
 	}
-	_, err = file.WriteString(id)
-	if err != nil {
-		return err
+	driver, err := h.sysfs.Driver(addr.String())
+	if err != nil && err != sysfs.ErrNoDriver {
+		return h.reportError(pdc, v1beta1.PCIDeviceClaimPhaseFailed, v1beta1.PCIDeviceClaimBound, "BindFailed", err)
 	}
-	file.Close()
-	return nil
+	pdcCopy := pdc.DeepCopy()
+	// The event is recorded when the claim becomes Bound, or the device is bound again after a reboot
+	boundNow := pdc.Status.Phase != v1beta1.PCIDeviceClaimPhaseBound
+	// The device is not bound to vfio-pci yet, or not anymore after a reboot
+	if driver != vfioDriver {
+		pd, err := h.pciDevice(pdc)
+		if err != nil {
+			return pdc, err
+		}
+		if pd == nil {
+			return h.reportError(pdc, v1beta1.PCIDeviceClaimPhasePending, v1beta1.PCIDeviceClaimBound, "DeviceNotFound",
+				fmt.Errorf("PCI device %s not found on node %s", addr, h.nodeName))
+		}
+		if !pd.IsHealthy() {
+			return h.reportError(pdc, v1beta1.PCIDeviceClaimPhaseFailed, v1beta1.PCIDeviceClaimBound, "DeviceUnhealthy",
+				fmt.Errorf("not enabling passthrough for PCI device %s, it reported uncorrectable errors", pd.Name))
+		}
+		if driver != "" && pdc.Status.KernelDriverToUnbind != driver {
+			// Record the driver before unbinding it, so that it's bound back on release even if binding fails
+			pdcCopy.Status.KernelDriverToUnbind = driver
+			setStatus(pdcCopy, v1beta1.PCIDeviceClaimPhaseBinding, metav1.Condition{
+				Type:    v1beta1.PCIDeviceClaimBound,
+				Status:  metav1.ConditionFalse,
+				Reason:  "Binding",
+				Message: fmt.Sprintf("Unbinding the device from %s", driver),
+			})
+			return h.pdcClient.UpdateStatus(pdcCopy)
+		}
+		logrus.Infof("Enabling passthrough for PCI device %s", pd.Name)
+		if err := h.bindVfio(pdc, addr.String(), driver); err != nil {
+			return h.reportError(pdc, v1beta1.PCIDeviceClaimPhaseFailed, v1beta1.PCIDeviceClaimBound, "BindFailed", err)
+		}
+		boundNow = true
+	}
+	if boundNow {
+		h.recorder.Eventf(pdc, corev1.EventTypeNormal, "BoundToVfio", "Bound PCI device %s to vfio-pci", addr)
+	}
+	pdcCopy.Status.PassthroughEnabled = true
+	setStatus(pdcCopy, v1beta1.PCIDeviceClaimPhaseBound, metav1.Condition{
+		Type:    v1beta1.PCIDeviceClaimBound,
+		Status:  metav1.ConditionTrue,
+		Reason:  "BoundToVfio",
+		Message: "The device is bound to vfio-pci",
+	})
+	return h.updateStatus(pdc, pdcCopy)
 }
 
-func unbindPCIDeviceFromDriver(addr string, driver string) error {
-	path := fmt.Sprintf("/sys/bus/pci/drivers/%s/unbind", driver)
-	file, err := os.OpenFile(path, os.O_WRONLY, 0400)
-	if err != nil {
-		return err
+func (h *Handler) bindVfio(pdc *v1beta1.PCIDeviceClaim, addr string, driver string) error {
+	h.loadVfioDrivers()
+	// The override only applies to this device, unlike adding its IDs to vfio-pci, which would
+	// also take every other device of the same model
+	if err := h.sysfs.SetDriverOverride(addr, vfioDriver); err != nil {
+		return fmt.Errorf("error setting the driver override of %s: %w", addr, err)
 	}
-	_, err = file.WriteString(addr)
-	if err != nil {
-		return err
+	if driver != "" {
+		if err := h.sysfs.Unbind(addr, driver); err != nil {
+			return fmt.Errorf("error unbinding %s from %s: %w", addr, driver, err)
+		}
+		h.recorder.Eventf(pdc, corev1.EventTypeNormal, "DriverUnbound", "Unbound PCI device %s from %s", addr, driver)
+	}
+	if err := h.sysfs.DriversProbe(addr); err != nil {
+		return fmt.Errorf("error binding %s to %s: %w", addr, vfioDriver, err)
+	}
+	if driver, err := h.sysfs.Driver(addr); err != nil || driver != vfioDriver {
+		return fmt.Errorf("%s is not bound to %s after probing it", addr, vfioDriver)
 	}
-	file.Close()
 	return nil
 }
 
-func unbindPCIDeviceFromVfioPCIDriver(addr string) error {
-	file, err := os.OpenFile("/sys/bus/pci/drivers/vfio-pci/unbind", os.O_WRONLY, 0400)
-	if err != nil {
-		return err
+// release unbinds the device from vfio-pci and binds it back to the driver it had before it was
+// claimed. The finalizer is only removed once the device is bound to that driver again.
+func (h *Handler) release(pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	if !hasFinalizer(pdc) {
+		return pdc, nil
 	}
-	_, err = file.WriteString(addr)
-	if err != nil {
-		return err
+	if meta.FindStatusCondition(pdc.Status.Conditions, v1beta1.PCIDeviceClaimReleased) == nil {
+		pdcCopy := pdc.DeepCopy()
+		pdcCopy.Status.PassthroughEnabled = false
+		setStatus(pdcCopy, v1beta1.PCIDeviceClaimPhaseReleasing, metav1.Condition{
+			Type:    v1beta1.PCIDeviceClaimReleased,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Releasing",
+			Message: "Binding the device back to its original driver",
+		})
+		return h.pdcClient.UpdateStatus(pdcCopy)
 	}
-	file.Close()
-	return nil
+	// The device was released already if only removing the finalizer failed
+	if addr, err := pdc.Spec.PCIAddress(); err == nil && !meta.IsStatusConditionTrue(pdc.Status.Conditions, v1beta1.PCIDeviceClaimReleased) {
+		released := metav1.Condition{
+			Type:    v1beta1.PCIDeviceClaimReleased,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Rebound",
+			Message: "The device is bound to its original driver again",
+		}
+		if _, err := os.Stat(h.sysfs.DevicePath(addr.String())); os.IsNotExist(err) {
+			// The device was unplugged, there's nothing to bind back
+			released.Reason = "DeviceRemoved"
+			released.Message = "The device is no longer on the bus"
+			h.recorder.Eventf(pdc, corev1.EventTypeNormal, "Released", "Released PCI device %s, it is no longer on the bus", addr)
+		} else {
+			if err := h.rebind(addr.String(), pdc.Status.KernelDriverToUnbind); err != nil {
+				return h.reportError(pdc, v1beta1.PCIDeviceClaimPhaseFailed, v1beta1.PCIDeviceClaimReleased, "RebindFailed", err)
+			}
+			if driver := pdc.Status.KernelDriverToUnbind; driver != "" && driver != vfioDriver {
+				h.recorder.Eventf(pdc, corev1.EventTypeNormal, "Released", "Released PCI device %s, it is bound to %s again", addr, driver)
+			} else {
+				h.recorder.Eventf(pdc, corev1.EventTypeNormal, "Released", "Released PCI device %s", addr)
+			}
+		}
+		pdcCopy := pdc.DeepCopy()
+		setStatus(pdcCopy, v1beta1.PCIDeviceClaimPhaseReleasing, released)
+		updated, err := h.pdcClient.UpdateStatus(pdcCopy)
+		if err != nil {
+			return pdc, err
+		}
+		pdc = updated
+	}
+	pdcCopy := pdc.DeepCopy()
+	pdcCopy.Finalizers = removeFinalizer(pdcCopy.Finalizers)
+	return h.pdcClient.Update(pdcCopy)
 }
 
-func (h Handler) reconcilePCIDeviceClaims(hostname string) error {
-	// Get all PCI Device Claims
-	var pdcNames map[string]string = make(map[string]string)
-	pdcs, err := h.pdcClient.List(metav1.ListOptions{})
-	if err != nil {
+func (h *Handler) rebind(addr string, originalDriver string) error {
+	driver, err := h.sysfs.Driver(addr)
+	if err != nil && err != sysfs.ErrNoDriver {
 		return err
 	}
-	for _, pdc := range pdcs.Items {
-		// Build up mapping
-		nodeAddr := fmt.Sprintf(
-			"%s-%s", pdc.Spec.NodeName, pdc.Spec.Address,
-		)
-		pdcNames[nodeAddr] = pdc.Name
+	if driver == vfioDriver {
+		logrus.Infof("Unbinding PCI device %s from vfio-pci", addr)
+		if err := h.sysfs.Unbind(addr, vfioDriver); err != nil {
+			return fmt.Errorf("error unbinding %s from %s: %w", addr, vfioDriver, err)
+		}
 	}
-	// Get all PCI Devices
-	pds, err := h.pdClient.List(metav1.ListOptions{})
-	if err != nil {
-		return err
+	if err := h.sysfs.SetDriverOverride(addr, ""); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error clearing the driver override of %s: %w", addr, err)
 	}
-	// Join PCI Devices with PCI Device Claims
-	// Perform the join using this map[node-addr]=>name
-	// This is possible because a (Node, PCIAddress) pair uniquely identifies a PCI Device
-	var pdNames map[string]string = make(map[string]string)
-	for _, pd := range pds.Items {
-		// Build up mapping
-		nodeAddr := fmt.Sprintf(
-			"%s-%s", pd.Status.NodeName, pd.Status.Address,
-		)
-		pdNames[nodeAddr] = pd.ObjectMeta.Name
-
-		// Check if PCI Device is already enabled for passthrough, but has no pre-existing PDC,
-		// if so, unbind the device (to force the user to make a proper PDC)
-		_, found := pdcNames[nodeAddr]
-		if !found && pd.Status.KernelDriverInUse == "vfio-pci" && hostname == pd.Status.NodeName {
-			logrus.Infof("PCI Device %s is bound to vfio-pci but has no Claim, attempting to unbind", pd.Status.Address)
-			err = unbindPCIDeviceFromVfioPCIDriver(pd.Status.Address)
-			if err != nil {
+	if originalDriver == "" || originalDriver == vfioDriver || driver == originalDriver {
+		return nil
+	}
+
+	if !h.sysfs.DriverLoaded(originalDriver) {
+		// Driver names usually match their module, if not, the modalias finds the module
+		if err := h.loadModule(originalDriver); err != nil {
+			modalias, aliasErr := h.sysfs.Modalias(addr)
+			if aliasErr != nil {
 				return err
 			}
-		}
-		// After reboot, the PCIDeviceClaim will be there but the PCIDevice won't be bound to vfio-pci
-		if found && pd.Status.KernelDriverInUse != "vfio-pci" && hostname == pd.Status.NodeName {
-			// Set PassthroughEnabled to false
-			for _, pdc := range pdcs.Items {
-				if pdc.Spec.Address == pd.Status.Address {
-					logrus.Infof("Passthrough disabled for device %s", pd.Name)
-					pdc.Status.PassthroughEnabled = false
-				}
+			if err := h.loadModule(modalias); err != nil {
+				return err
 			}
 		}
+		// Loading the driver binds it to the unbound devices it supports
+		if driver, _ := h.sysfs.Driver(addr); driver == originalDriver {
+			return nil
+		}
 	}
+	logrus.Infof("Binding PCI device %s to %s", addr, originalDriver)
+	if err := h.sysfs.Bind(addr, originalDriver); err != nil {
+		return fmt.Errorf("error binding %s to %s: %w", addr, originalDriver, err)
+	}
+	if driver, err := h.sysfs.Driver(addr); err != nil || driver != originalDriver {
+		return fmt.Errorf("%s is not bound to %s after binding it", addr, originalDriver)
+	}
+	return nil
+}
 
-	// Only load the vfio drivers if there are any PCI Device Claims
-	if len(pdcs.Items) > 0 {
-		loadVfioDrivers()
-	}
-
-	// Get those PCI Device Claims for this node
-	for _, pdc := range pdcs.Items {
-		if pdc.Spec.NodeName == hostname {
-			if !pdc.Status.PassthroughEnabled {
-				logrus.Infof("Attempting to enable passthrough")
-				// Get PCIDevice for the PCIDeviceClaim
-				name := pdNames[pdc.Spec.NodeAddr()]
-				pd, err := h.pdClient.Get(name, metav1.GetOptions{})
-				if err != nil {
-					return err
-				}
-				pdc.Status.KernelDriverToUnbind = pd.Status.KernelDriverInUse
-				if pd.Status.KernelDriverInUse == "vfio-pci" {
-					pdc.Status.PassthroughEnabled = true
-				} else {
-					// Only unbind from driver is a driver is currently in use
-					if strings.TrimSpace(pd.Status.KernelDriverInUse) != "" {
-						err = unbindPCIDeviceFromDriver(pd.Status.Address, pd.Status.KernelDriverInUse)
-						if err != nil {
-							pdc.Status.PassthroughEnabled = false
-							return err
-						}
-					}
-					err = addNewIdToVfioPCIDriver(pd.Status.VendorId, pd.Status.DeviceId)
-					if err != nil {
-						pdc.Status.PassthroughEnabled = false
-						return err
-					}
-					pdc.Status.PassthroughEnabled = true
-				}
-				_, err = h.pdcClient.Update(&pdc)
-				if err != nil {
-					return err
-				}
-				_, err = h.pdcClient.UpdateStatus(&pdc)
-				if err != nil {
-					return err
-				}
-			}
-			if pdc.DeletionTimestamp != nil {
-				logrus.Infof("Attempting to unbind PCI device %s from vfio-pci", pdc.Spec.Address)
-				err = unbindPCIDeviceFromVfioPCIDriver(pdc.Spec.Address)
-				if err != nil {
-					return err
-				}
-			}
+// setStatus moves the claim to the phase, with the condition that explains it, for the
+// current generation of the claim
+func setStatus(pdc *v1beta1.PCIDeviceClaim, phase v1beta1.PCIDeviceClaimPhase, condition metav1.Condition) {
+	condition.ObservedGeneration = pdc.Generation
+	meta.SetStatusCondition(&pdc.Status.Conditions, condition)
+	pdc.Status.SetPhase(phase)
+	pdc.Status.ObservedGeneration = pdc.Generation
+}
+
+func (h *Handler) updateStatus(pdc *v1beta1.PCIDeviceClaim, pdcCopy *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	if equality.Semantic.DeepEqual(pdc.Status, pdcCopy.Status) {
+		return pdc, nil
+	}
+	return h.pdcClient.UpdateStatus(pdcCopy)
+}
+
+// reportError reports the error on the claim and in an event, with the condition's reason, and
+// returns it to retry
+func (h *Handler) reportError(
+	pdc *v1beta1.PCIDeviceClaim,
+	phase v1beta1.PCIDeviceClaimPhase,
+	conditionType string,
+	reason string,
+	err error,
+) (*v1beta1.PCIDeviceClaim, error) {
+	h.recorder.Event(pdc, corev1.EventTypeWarning, reason, err.Error())
+	pdcCopy := pdc.DeepCopy()
+	setStatus(pdcCopy, phase, metav1.Condition{
+		Type:    conditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: err.Error(),
+	})
+	if updated, updateErr := h.updateStatus(pdc, pdcCopy); updateErr == nil {
+		pdc = updated
+	}
+	return pdc, err
+}
+
+// OnDeviceChange unbinds a device of the node from vfio-pci if no claim references it, so that
+// devices are only passed through by making a proper claim
+func (h *Handler) OnDeviceChange(key string, pd *v1beta1.PCIDevice) (*v1beta1.PCIDevice, error) {
+	if pd == nil || pd.DeletionTimestamp != nil || pd.Status.NodeName != h.nodeName || pd.Status.KernelDriverInUse != vfioDriver {
+		return pd, nil
+	}
+	pdcs, err := h.pdcCache.GetByIndex(claimByNodeAddrIndex, deviceNodeAddr(pd))
+	if err != nil {
+		return pd, err
+	}
+	if len(pdcs) > 0 {
+		return pd, nil
+	}
+	// The status may be stale, e.g. if the device was released in the meantime
+	driver, err := h.sysfs.Driver(pd.Status.Address)
+	if err != nil || driver != vfioDriver {
+		return pd, nil
+	}
+	logrus.Infof("PCI Device %s is bound to vfio-pci but has no Claim, attempting to unbind", pd.Status.Address)
+	if err := h.sysfs.Unbind(pd.Status.Address, vfioDriver); err != nil {
+		return pd, fmt.Errorf("error unbinding %s from %s: %w", pd.Status.Address, vfioDriver, err)
+	}
+	if err := h.sysfs.SetDriverOverride(pd.Status.Address, ""); err != nil && !os.IsNotExist(err) {
+		return pd, fmt.Errorf("error clearing the driver override of %s: %w", pd.Status.Address, err)
+	}
+	// Give the device back to its native driver, e.g. a NIC that vfio-pci took through new_id
+	if err := h.sysfs.DriversProbe(pd.Status.Address); err != nil {
+		return pd, fmt.Errorf("error probing a driver for %s: %w", pd.Status.Address, err)
+	}
+	if driver, _ := h.sysfs.Driver(pd.Status.Address); driver != "" {
+		h.recorder.Eventf(pd, corev1.EventTypeNormal, "OrphanedVfioBindingRemoved",
+			"Unbound the device from vfio-pci, as no PCIDeviceClaim references it, it is bound to %s now", driver)
+	} else {
+		h.recorder.Event(pd, corev1.EventTypeNormal, "OrphanedVfioBindingRemoved",
+			"Unbound the device from vfio-pci, as no PCIDeviceClaim references it, no driver took it")
+	}
+	return pd, nil
+}
+
+// pciDevice returns the PCIDevice of the claim, or nil if it doesn't exist
+func (h *Handler) pciDevice(pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDevice, error) {
+	// This is possible because a (Node, PCIAddress) pair uniquely identifies a PCI Device
+	pds, err := h.pdCache.GetByIndex(deviceByNodeAddrIndex, pdc.Spec.NodeAddr())
+	if err != nil || len(pds) == 0 {
+		return nil, err
+	}
+	return pds[0], nil
+}
+
+func hasFinalizer(pdc *v1beta1.PCIDeviceClaim) bool {
+	for _, f := range pdc.Finalizers {
+		if f == v1beta1.PCIDeviceClaimFinalizer {
+			return true
 		}
 	}
+	return false
+}
 
-	return nil
+func removeFinalizer(finalizers []string) []string {
+	var result []string
+	for _, f := range finalizers {
+		if f != v1beta1.PCIDeviceClaimFinalizer {
+			result = append(result, f)
+		}
+	}
+	return result
 }
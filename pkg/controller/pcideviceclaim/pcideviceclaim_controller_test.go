@@ -0,0 +1,398 @@
+package pcideviceclaim
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/sysfs"
+	"github.com/harvester/pcidevices/pkg/sysfs/sysfstest"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+var nic = sysfstest.Device{
+	Addr:   "0000:04:00.0",
+	Vendor: 0x8086,
+	Device: 0x1521,
+	Class:  0x020000,
+	Driver: "igb",
+	Extra:  map[string]string{"driver_override": "(null)"},
+}
+
+func newClaim(nodeName string, address string) *v1beta1.PCIDeviceClaim {
+	return &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName + "-nic"},
+		Spec:       v1beta1.PCIDeviceClaimSpec{NodeName: nodeName, Address: address, UserName: "yuri"},
+	}
+}
+
+func newDevice(nodeName string, d sysfstest.Device) *v1beta1.PCIDevice {
+	return &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName + "-" + d.Addr},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:           d.Addr,
+			NodeName:          nodeName,
+			VendorId:          int(d.Vendor),
+			DeviceId:          int(d.Device),
+			KernelDriverInUse: d.Driver,
+		},
+	}
+}
+
+// newHandler returns a handler for node1, which has the NIC, and the fake sysfs tree of the node
+func newHandler(t *testing.T, objects ...runtime.Object) (*Handler, *sysfstest.Tree) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(nic)
+	for _, driver := range []string{"igb", "vfio-pci"} {
+		for _, file := range []string{"bind", "unbind", "remove_id"} {
+			tree.WriteFile(filepath.Join(tree.DriversDir(), driver, file), "")
+		}
+	}
+	tree.WriteFile(filepath.Join(tree.Root, "bus", "pci", "drivers_probe"), "")
+	tree.WriteFile(filepath.Join(tree.Root, "module", "vfio_iommu_type1", "refcnt"), "0")
+	client := fake.NewSimpleClientset(objects...)
+	pdcCache := fakeclients.NewPCIDeviceClaimCache(client.DevicesV1beta1().PCIDeviceClaims)
+	pdCache := fakeclients.NewPCIDeviceCache(client.DevicesV1beta1().PCIDevices)
+	addIndexers(pdcCache, pdCache)
+	return &Handler{
+		pdcClient:  fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+		pdcCache:   pdcCache,
+		pdCache:    pdCache,
+		sysfs:      sysfs.New(tree.Root, ""),
+		nodeName:   "node1",
+		recorder:   record.NewFakeRecorder(100),
+		loadModule: func(name string) error { return fmt.Errorf("unexpected modprobe %s", name) },
+	}, tree
+}
+
+// events returns the events recorded so far
+func events(h *Handler) []string {
+	var result []string
+	for {
+		select {
+		case event := <-h.recorder.(*record.FakeRecorder).Events:
+			result = append(result, event)
+		default:
+			return result
+		}
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	value, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(value))
+}
+
+func readDriverFile(t *testing.T, tree *sysfstest.Tree, driver string, file string) string {
+	return readFile(t, filepath.Join(tree.DriversDir(), driver, file))
+}
+
+func TestBind(t *testing.T) {
+	pdc := newClaim("node1", "04:00.0")
+	h, tree := newHandler(t, pdc, newDevice("node1", nic))
+
+	// The finalizer is added first
+	pdc, err := h.OnChange(pdc.Name, pdc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasFinalizer(pdc) {
+		t.Fatalf("expected the finalizer to be added, got %v", pdc.Finalizers)
+	}
+	// Then the driver in use, before it's unbound
+	pdc, err = h.OnChange(pdc.Name, pdc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdc.Status.KernelDriverToUnbind != "igb" || pdc.Status.PassthroughEnabled || pdc.Status.Phase != v1beta1.PCIDeviceClaimPhaseBinding {
+		t.Fatalf("expected igb to be recorded, got %+v", pdc.Status)
+	}
+
+	// The fake tree doesn't probe the device, so it's not bound to vfio-pci after probing it
+	pdc, err = h.OnChange(pdc.Name, pdc)
+	if err == nil {
+		t.Fatal("expected an error while the device is not bound to vfio-pci")
+	}
+	if pdc.Status.Phase != v1beta1.PCIDeviceClaimPhaseFailed || boundReason(pdc) != "BindFailed" {
+		t.Fatalf("expected the failure to be reported, got %+v", pdc.Status)
+	}
+	if override := readFile(t, filepath.Join(tree.DevicesDir(), nic.Addr, "driver_override")); override != "vfio-pci" {
+		t.Fatalf("expected the driver override to be vfio-pci, got %q", override)
+	}
+	if unbound := readDriverFile(t, tree, "igb", "unbind"); unbound != nic.Addr {
+		t.Fatalf("expected %s to be unbound from igb, got %q", nic.Addr, unbound)
+	}
+	if probed := readFile(t, filepath.Join(tree.Root, "bus", "pci", "drivers_probe")); probed != nic.Addr {
+		t.Fatalf("expected %s to be probed, got %q", nic.Addr, probed)
+	}
+
+	tree.Bind(nic.Addr, "vfio-pci")
+	pdc, err = h.OnChange(pdc.Name, pdc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pdc.Status.PassthroughEnabled || pdc.Status.KernelDriverToUnbind != "igb" || pdc.Status.Phase != v1beta1.PCIDeviceClaimPhaseBound {
+		t.Fatalf("unexpected status %+v", pdc.Status)
+	}
+	if !meta.IsStatusConditionTrue(pdc.Status.Conditions, v1beta1.PCIDeviceClaimBound) {
+		t.Fatalf("expected the Bound condition to be true, got %+v", pdc.Status.Conditions)
+	}
+	expectedEvents := []string{
+		"Normal DriverUnbound Unbound PCI device 0000:04:00.0 from igb",
+		"Warning BindFailed 0000:04:00.0 is not bound to vfio-pci after probing it",
+		"Normal BoundToVfio Bound PCI device 0000:04:00.0 to vfio-pci",
+	}
+	if recorded := events(h); !reflect.DeepEqual(recorded, expectedEvents) {
+		t.Fatalf("expected events %q, got %q", expectedEvents, recorded)
+	}
+}
+
+func boundReason(pdc *v1beta1.PCIDeviceClaim) string {
+	if condition := meta.FindStatusCondition(pdc.Status.Conditions, v1beta1.PCIDeviceClaimBound); condition != nil {
+		return condition.Reason
+	}
+	return ""
+}
+
+func TestRemoveVfioDynamicIDs(t *testing.T) {
+	h, tree := newHandler(t)
+	// The GPU's ID was configured through the module parameter
+	tree.AddDevice(sysfstest.Device{Addr: "0000:65:00.0", Vendor: 0x10de, Device: 0x1eb8, Class: 0x030200})
+	tree.WriteFile(filepath.Join(tree.Root, "module", "vfio_pci", "parameters", "ids"), "10de:1eb8,10de:10f8")
+
+	h.removeVfioDynamicIDs()
+	if removed := readDriverFile(t, tree, "vfio-pci", "remove_id"); removed != "8086 1521" {
+		t.Fatalf("expected only the NIC's ID to be removed, got %q", removed)
+	}
+}
+
+func TestLoadVfioDrivers(t *testing.T) {
+	h, tree := newHandler(t)
+	if err := os.RemoveAll(filepath.Join(tree.Root, "module", "vfio_iommu_type1")); err != nil {
+		t.Fatal(err)
+	}
+	var loaded []string
+	h.loadModule = func(name string) error {
+		loaded = append(loaded, name)
+		return nil
+	}
+
+	// vfio-pci is registered on the bus already
+	h.loadVfioDrivers()
+	if !reflect.DeepEqual(loaded, []string{"vfio_iommu_type1"}) {
+		t.Fatalf("expected only vfio_iommu_type1 to be loaded, got %v", loaded)
+	}
+}
+
+func TestBindIgnoresOtherNodes(t *testing.T) {
+	pdc := newClaim("node2", nic.Addr)
+	h, _ := newHandler(t, pdc)
+	updated, err := h.OnChange(pdc.Name, pdc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasFinalizer(updated) || updated.Status.PassthroughEnabled {
+		t.Fatalf("expected the claim of another node to be left alone, got %+v", updated)
+	}
+}
+
+func TestBindUnhealthyDevice(t *testing.T) {
+	pdc := newClaim("node1", nic.Addr)
+	pdc.Finalizers = []string{v1beta1.PCIDeviceClaimFinalizer}
+	pd := newDevice("node1", nic)
+	pd.Status.Conditions = []metav1.Condition{{Type: v1beta1.PCIDeviceHealthy, Status: metav1.ConditionFalse}}
+	h, tree := newHandler(t, pdc, pd)
+
+	// The error puts the claim back on the work queue
+	updated, err := h.OnChange(pdc.Name, pdc)
+	if err == nil {
+		t.Fatal("expected an error binding an unhealthy device")
+	}
+	if updated.Status.Phase != v1beta1.PCIDeviceClaimPhaseFailed || boundReason(updated) != "DeviceUnhealthy" {
+		t.Fatalf("expected the failure to be reported, got %+v", updated.Status)
+	}
+	if unbound := readDriverFile(t, tree, "igb", "unbind"); unbound != "" {
+		t.Fatalf("expected the device to stay bound to igb, got %q", unbound)
+	}
+}
+
+func newDeletedClaim(originalDriver string) *v1beta1.PCIDeviceClaim {
+	now := metav1.Now()
+	pdc := newClaim("node1", nic.Addr)
+	pdc.Finalizers = []string{v1beta1.PCIDeviceClaimFinalizer}
+	pdc.DeletionTimestamp = &now
+	pdc.Status = v1beta1.PCIDeviceClaimStatus{KernelDriverToUnbind: originalDriver, PassthroughEnabled: true}
+	return pdc
+}
+
+// startRelease moves the claim to the Releasing phase, which happens before the device is touched
+func startRelease(t *testing.T, h *Handler, pdc *v1beta1.PCIDeviceClaim) *v1beta1.PCIDeviceClaim {
+	updated, err := h.OnChange(pdc.Name, pdc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.Phase != v1beta1.PCIDeviceClaimPhaseReleasing || updated.Status.PassthroughEnabled {
+		t.Fatalf("expected the claim to be releasing, got %+v", updated.Status)
+	}
+	return updated
+}
+
+func TestRelease(t *testing.T) {
+	pdc := newDeletedClaim("igb")
+	h, tree := newHandler(t, pdc)
+	tree.Bind(nic.Addr, "vfio-pci")
+	pdc = startRelease(t, h, pdc)
+
+	// The fake tree doesn't bind the device, so it's not bound to igb after binding it
+	updated, err := h.OnChange(pdc.Name, pdc)
+	if err == nil {
+		t.Fatal("expected an error while the device is not bound to igb")
+	}
+	if unbound := readDriverFile(t, tree, "vfio-pci", "unbind"); unbound != nic.Addr {
+		t.Fatalf("expected %s to be unbound from vfio-pci, got %q", nic.Addr, unbound)
+	}
+	if bound := readDriverFile(t, tree, "igb", "bind"); bound != nic.Addr {
+		t.Fatalf("expected %s to be bound to igb, got %q", nic.Addr, bound)
+	}
+	if !hasFinalizer(updated) || updated.Status.Phase != v1beta1.PCIDeviceClaimPhaseFailed ||
+		!meta.IsStatusConditionFalse(updated.Status.Conditions, v1beta1.PCIDeviceClaimReleased) {
+		t.Fatalf("expected the claim to be kept with the failure, got %+v", updated)
+	}
+
+	// Once the kernel bound it, the claim can go
+	tree.Bind(nic.Addr, "igb")
+	updated, err = h.OnChange(updated.Name, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasFinalizer(updated) {
+		t.Fatalf("expected the finalizer to be removed, got %v", updated.Finalizers)
+	}
+	if condition := meta.FindStatusCondition(updated.Status.Conditions, v1beta1.PCIDeviceClaimReleased); condition == nil ||
+		condition.Status != metav1.ConditionTrue || condition.Reason != "Rebound" {
+		t.Fatalf("expected the claim to be Released, got %+v", updated.Status.Conditions)
+	}
+	expectedEvents := []string{
+		"Warning RebindFailed 0000:04:00.0 is not bound to igb after binding it",
+		"Normal Released Released PCI device 0000:04:00.0, it is bound to igb again",
+	}
+	if recorded := events(h); !reflect.DeepEqual(recorded, expectedEvents) {
+		t.Fatalf("expected events %q, got %q", expectedEvents, recorded)
+	}
+}
+
+func TestReleaseRemovedDevice(t *testing.T) {
+	pdc := newDeletedClaim("igb")
+	h, tree := newHandler(t, pdc)
+	pdc = startRelease(t, h, pdc)
+
+	// The device was unplugged while it was claimed
+	tree.RemoveDevice(nic.Addr)
+	updated, err := h.OnChange(pdc.Name, pdc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasFinalizer(updated) {
+		t.Fatalf("expected the finalizer to be removed, got %v", updated.Finalizers)
+	}
+	if condition := meta.FindStatusCondition(updated.Status.Conditions, v1beta1.PCIDeviceClaimReleased); condition == nil ||
+		condition.Status != metav1.ConditionTrue || condition.Reason != "DeviceRemoved" {
+		t.Fatalf("expected the claim to be Released, got %+v", updated.Status.Conditions)
+	}
+	if bound := readDriverFile(t, tree, "igb", "bind"); bound != "" {
+		t.Fatalf("expected no driver to be bound, got %q", bound)
+	}
+}
+
+func TestReleaseAfterRebound(t *testing.T) {
+	// Only removing the finalizer failed the last time
+	pdc := newDeletedClaim("igb")
+	setStatus(pdc, v1beta1.PCIDeviceClaimPhaseReleasing, metav1.Condition{
+		Type:   v1beta1.PCIDeviceClaimReleased,
+		Status: metav1.ConditionTrue,
+		Reason: "Rebound",
+	})
+	h, tree := newHandler(t, pdc)
+	tree.Bind(nic.Addr, "vfio-pci")
+
+	updated, err := h.OnChange(pdc.Name, pdc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasFinalizer(updated) {
+		t.Fatalf("expected the finalizer to be removed, got %v", updated.Finalizers)
+	}
+	if unbound := readDriverFile(t, tree, "vfio-pci", "unbind"); unbound != "" {
+		t.Fatalf("expected the device not to be released again, got %q unbound", unbound)
+	}
+}
+
+func TestReleaseLoadsModule(t *testing.T) {
+	pdc := newDeletedClaim("e1000e")
+	h, tree := newHandler(t, pdc)
+	tree.Bind(nic.Addr, "vfio-pci")
+	h.loadModule = func(name string) error {
+		if name != "e1000e" {
+			return fmt.Errorf("unexpected modprobe %s", name)
+		}
+		// Loading the module binds the device, as it's no longer claimed by vfio-pci
+		tree.WriteFile(filepath.Join(tree.DriversDir(), "e1000e", "bind"), "")
+		tree.Bind(nic.Addr, "e1000e")
+		return nil
+	}
+
+	pdc = startRelease(t, h, pdc)
+	updated, err := h.OnChange(pdc.Name, pdc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasFinalizer(updated) {
+		t.Fatalf("expected the finalizer to be removed, got %v", updated.Finalizers)
+	}
+}
+
+func TestOnDeviceChangeUnbindsUnclaimedDevice(t *testing.T) {
+	claimed := newDevice("node1", nic)
+	claimed.Status.KernelDriverInUse = "vfio-pci"
+	h, tree := newHandler(t, newClaim("node1", nic.Addr), claimed)
+	tree.Bind(nic.Addr, "vfio-pci")
+
+	if _, err := h.OnDeviceChange(claimed.Name, claimed); err != nil {
+		t.Fatal(err)
+	}
+	if unbound := readDriverFile(t, tree, "vfio-pci", "unbind"); unbound != "" {
+		t.Fatalf("expected a claimed device to stay bound, got %q", unbound)
+	}
+	if err := h.pdcClient.Delete("node1-nic", &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.OnDeviceChange(claimed.Name, claimed); err != nil {
+		t.Fatal(err)
+	}
+	if unbound := readDriverFile(t, tree, "vfio-pci", "unbind"); unbound != nic.Addr {
+		t.Fatalf("expected an unclaimed device to be unbound from vfio-pci, got %q", unbound)
+	}
+	if probed := readFile(t, filepath.Join(tree.Root, "bus", "pci", "drivers_probe")); probed != nic.Addr {
+		t.Fatalf("expected a driver to be probed for %s, got %q", nic.Addr, probed)
+	}
+	// The fake tree doesn't unbind the device, so it still has the driver it had
+	expectedEvents := []string{
+		"Normal OrphanedVfioBindingRemoved Unbound the device from vfio-pci, as no PCIDeviceClaim references it, it is bound to vfio-pci now",
+	}
+	if recorded := events(h); !reflect.DeepEqual(recorded, expectedEvents) {
+		t.Fatalf("expected events %q, got %q", expectedEvents, recorded)
+	}
+}
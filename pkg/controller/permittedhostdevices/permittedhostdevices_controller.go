@@ -0,0 +1,253 @@
+package permittedhostdevices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+// ManagedResourcesAnnotation is set on the KubeVirt CR to the resource names of the
+// pciHostDevices entries that this controller added, so that entries added by hand are left alone
+const ManagedResourcesAnnotation = "pcidevices.harvesterhci.io/managed-pci-host-devices"
+
+var (
+	kubeVirtResource = schema.GroupVersionResource{Group: "kubevirt.io", Version: "v1", Resource: "kubevirts"}
+
+	pciHostDevicesPath = []string{"spec", "configuration", "permittedHostDevices", "pciHostDevices"}
+)
+
+// syncKey is enqueued on the claims controller to sync the KubeVirt CRs, so that a burst of changes
+// is handled once. Claim names can't contain underscores, so it can't be a claim.
+const syncKey = "_permittedhostdevices_sync"
+
+// device is what the permitted host devices depend on of a PCIDevice
+type device struct {
+	nodeAddr     string
+	resourceName string
+}
+
+type Handler struct {
+	deviceCache    ctl.PCIDeviceCache
+	claimCache     ctl.PCIDeviceClaimCache
+	kubeVirtClient dynamic.NamespaceableResourceInterface
+	enqueue        func(name string)
+
+	lock sync.Mutex
+	// claims holds the NodeAddr of the claims that aren't being deleted, by name
+	claims map[string]string
+	// devices holds the PCIDevices by name
+	devices map[string]device
+}
+
+// Register starts the controller that keeps the permitted PCI host devices of KubeVirt in sync with
+// the models of the claimed devices. It uses the dynamic client, so that KubeVirt doesn't have to be
+// installed. It should only run in one place in the cluster.
+func Register(
+	ctx context.Context,
+	pd ctl.PCIDeviceController,
+	pdc ctl.PCIDeviceClaimController,
+	dynamicClient dynamic.Interface,
+) {
+	logrus.Info("Registering KubeVirt Permitted Host Devices controller")
+	handler := &Handler{
+		deviceCache:    pd.Cache(),
+		claimCache:     pdc.Cache(),
+		kubeVirtClient: dynamicClient.Resource(kubeVirtResource),
+		enqueue:        pdc.Enqueue,
+		claims:         map[string]string{},
+		devices:        map[string]device{},
+	}
+	pd.OnChange(ctx, "pcidevice-permittedhostdevices", handler.OnDeviceChange)
+	pdc.OnChange(ctx, "pcideviceclaim-permittedhostdevices", handler.OnClaimChange)
+}
+
+// OnClaimChange syncs the KubeVirt CRs when a claim is created or deleted, and carries out the sync
+func (h *Handler) OnClaimChange(key string, pdc *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	if key == syncKey {
+		return pdc, h.sync()
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	nodeAddr, known := h.claims[key]
+	if pdc == nil || pdc.DeletionTimestamp != nil {
+		if known {
+			delete(h.claims, key)
+			h.enqueue(syncKey)
+		}
+		return pdc, nil
+	}
+	if !known || nodeAddr != pdc.Spec.NodeAddr() {
+		h.claims[key] = pdc.Spec.NodeAddr()
+		h.enqueue(syncKey)
+	}
+	return pdc, nil
+}
+
+// OnDeviceChange syncs the KubeVirt CRs when the resource name of a claimed device changes. Other
+// changes, like status refreshes, don't change the permitted host devices.
+func (h *Handler) OnDeviceChange(key string, pd *v1beta1.PCIDevice) (*v1beta1.PCIDevice, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	previous := h.devices[key]
+	var current device
+	if pd != nil {
+		current = device{
+			nodeAddr:     pd.Status.NodeName + "-" + v1beta1.CanonicalPCIAddress(pd.Status.Address),
+			resourceName: pd.Status.ResourceName,
+		}
+		h.devices[key] = current
+	} else {
+		delete(h.devices, key)
+	}
+	if current != previous && (h.claimed(previous.nodeAddr) || h.claimed(current.nodeAddr)) {
+		h.enqueue(syncKey)
+	}
+	return pd, nil
+}
+
+func (h *Handler) claimed(nodeAddr string) bool {
+	for _, claimed := range h.claims {
+		if claimed == nodeAddr {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) sync() error {
+	desired, err := h.claimedModels()
+	if err != nil {
+		return err
+	}
+	kubeVirts, err := h.kubeVirtClient.List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing KubeVirt CRs: %w", err)
+	}
+	for i := range kubeVirts.Items {
+		if err := h.syncKubeVirt(&kubeVirts.Items[i], desired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimedModels returns the pciVendorSelector of every resource name that is claimed on any node
+func (h *Handler) claimedModels() (map[string]string, error) {
+	pds, err := h.deviceCache.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	byNodeAddr := map[string]*v1beta1.PCIDevice{}
+	for _, pd := range pds {
+		byNodeAddr[pd.Status.NodeName+"-"+v1beta1.CanonicalPCIAddress(pd.Status.Address)] = pd
+	}
+	pdcs, err := h.claimCache.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]string{}
+	for _, pdc := range pdcs {
+		if pdc.DeletionTimestamp != nil {
+			continue
+		}
+		pd, ok := byNodeAddr[pdc.Spec.NodeAddr()]
+		if !ok || pd.Status.ResourceName == "" {
+			continue
+		}
+		result[pd.Status.ResourceName] = fmt.Sprintf("%04X:%04X", pd.Status.VendorId, pd.Status.DeviceId)
+	}
+	return result, nil
+}
+
+// syncKubeVirt adds an entry for every desired resource name that doesn't have one yet, and removes
+// the entries it added for resource names that are no longer desired
+func (h *Handler) syncKubeVirt(kv *unstructured.Unstructured, desired map[string]string) error {
+	managed := map[string]bool{}
+	if value := kv.GetAnnotations()[ManagedResourcesAnnotation]; value != "" {
+		var resourceNames []string
+		if err := json.Unmarshal([]byte(value), &resourceNames); err != nil {
+			return fmt.Errorf("error parsing %s of KubeVirt %s/%s: %w", ManagedResourcesAnnotation, kv.GetNamespace(), kv.GetName(), err)
+		}
+		for _, resourceName := range resourceNames {
+			managed[resourceName] = true
+		}
+	}
+	existing, _, err := unstructured.NestedSlice(kv.Object, pciHostDevicesPath...)
+	if err != nil {
+		return fmt.Errorf("error reading pciHostDevices of KubeVirt %s/%s: %w", kv.GetNamespace(), kv.GetName(), err)
+	}
+
+	var entries []interface{}
+	var nextManaged []string
+	present := map[string]bool{}
+	for _, e := range existing {
+		entry, _ := e.(map[string]interface{})
+		resourceName, _ := entry["resourceName"].(string)
+		if managed[resourceName] {
+			if _, ok := desired[resourceName]; !ok {
+				logrus.Infof("Removing %s from the permitted host devices of KubeVirt %s/%s", resourceName, kv.GetNamespace(), kv.GetName())
+				continue
+			}
+			nextManaged = append(nextManaged, resourceName)
+		}
+		present[resourceName] = true
+		entries = append(entries, e)
+	}
+	var added []string
+	for resourceName := range desired {
+		if !present[resourceName] {
+			added = append(added, resourceName)
+		}
+	}
+	sort.Strings(added)
+	for _, resourceName := range added {
+		logrus.Infof("Adding %s to the permitted host devices of KubeVirt %s/%s", resourceName, kv.GetNamespace(), kv.GetName())
+		entries = append(entries, map[string]interface{}{
+			"pciVendorSelector": desired[resourceName],
+			"resourceName":      resourceName,
+			// The devices are advertised by our device plugins, not KubeVirt's
+			"externalResourceProvider": true,
+		})
+		nextManaged = append(nextManaged, resourceName)
+	}
+	sort.Strings(nextManaged)
+
+	kvCopy := kv.DeepCopy()
+	if len(entries) == 0 {
+		unstructured.RemoveNestedField(kvCopy.Object, pciHostDevicesPath...)
+	} else if err := unstructured.SetNestedSlice(kvCopy.Object, entries, pciHostDevicesPath...); err != nil {
+		return err
+	}
+	annotations := kvCopy.GetAnnotations()
+	if len(nextManaged) == 0 {
+		delete(annotations, ManagedResourcesAnnotation)
+	} else {
+		value, err := json.Marshal(nextManaged)
+		if err != nil {
+			return err
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[ManagedResourcesAnnotation] = string(value)
+	}
+	kvCopy.SetAnnotations(annotations)
+	if equality.Semantic.DeepEqual(kv, kvCopy) {
+		return nil
+	}
+	_, err = h.kubeVirtClient.Namespace(kv.GetNamespace()).Update(context.TODO(), kvCopy, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,184 @@
+package permittedhostdevices
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+var manualEntry = map[string]interface{}{
+	"pciVendorSelector": "10DE:1DB6",
+	"resourceName":      "nvidia.com/GV100GL_Tesla_V100",
+}
+
+func newKubeVirt() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kubevirt.io/v1",
+		"kind":       "KubeVirt",
+		"metadata":   map[string]interface{}{"name": "kubevirt", "namespace": "harvester-system"},
+		"spec": map[string]interface{}{
+			"configuration": map[string]interface{}{
+				"permittedHostDevices": map[string]interface{}{
+					"pciHostDevices": []interface{}{manualEntry},
+				},
+			},
+		},
+	}}
+}
+
+func newDevice(nodeName string, address string, vendorId int, deviceId int) *v1beta1.PCIDevice {
+	return &v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName + "-" + address},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:      address,
+			NodeName:     nodeName,
+			VendorId:     vendorId,
+			DeviceId:     deviceId,
+			ResourceName: v1beta1.ResourceName(uint16(vendorId), uint16(deviceId)),
+		},
+	}
+}
+
+func newClaim(nodeName string, address string) *v1beta1.PCIDeviceClaim {
+	return &v1beta1.PCIDeviceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName + "-" + address},
+		Spec:       v1beta1.PCIDeviceClaimSpec{NodeName: nodeName, Address: address},
+	}
+}
+
+func newHandler(objects ...runtime.Object) (*Handler, *fake.Clientset) {
+	client := fake.NewSimpleClientset(objects...)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{kubeVirtResource: "KubeVirtList"}, newKubeVirt())
+	return &Handler{
+		deviceCache:    fakeclients.NewPCIDeviceCache(client.DevicesV1beta1().PCIDevices),
+		claimCache:     fakeclients.NewPCIDeviceClaimCache(client.DevicesV1beta1().PCIDeviceClaims),
+		kubeVirtClient: dynamicClient.Resource(kubeVirtResource),
+		claims:         map[string]string{},
+		devices:        map[string]device{},
+	}, client
+}
+
+func (h *Handler) pciHostDevices(t *testing.T) ([]interface{}, string) {
+	kv, err := h.kubeVirtClient.Namespace("harvester-system").Get(context.TODO(), "kubevirt", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _, err := unstructured.NestedSlice(kv.Object, pciHostDevicesPath...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries, kv.GetAnnotations()[ManagedResourcesAnnotation]
+}
+
+func TestSync(t *testing.T) {
+	h, client := newHandler(
+		newDevice("node1", "0000:01:00.0", 0x10de, 0x1eb8),
+		newDevice("node2", "0000:01:00.0", 0x10de, 0x1eb8),
+		newDevice("node1", "0000:00:1f.6", 0x8086, 0x15bc),
+		newClaim("node1", "0000:01:00.0"),
+		newClaim("node2", "0000:01:00.0"),
+	)
+	if err := h.sync(); err != nil {
+		t.Fatal(err)
+	}
+	entries, managed := h.pciHostDevices(t)
+	expected := []interface{}{
+		manualEntry,
+		map[string]interface{}{
+			"pciVendorSelector":        "10DE:1EB8",
+			"resourceName":             "pcidevices.harvesterhci.io/10de-1eb8",
+			"externalResourceProvider": true,
+		},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+	if managed != `["pcidevices.harvesterhci.io/10de-1eb8"]` {
+		t.Fatalf("unexpected %s annotation %s", ManagedResourcesAnnotation, managed)
+	}
+
+	// The entry stays while any claim references the model, and is removed with the last one
+	if err := client.DevicesV1beta1().PCIDeviceClaims().Delete(context.TODO(), "node1-0000:01:00.0", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := h.pciHostDevices(t); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+	if err := client.DevicesV1beta1().PCIDeviceClaims().Delete(context.TODO(), "node2-0000:01:00.0", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.sync(); err != nil {
+		t.Fatal(err)
+	}
+	entries, managed = h.pciHostDevices(t)
+	if !reflect.DeepEqual(entries, []interface{}{manualEntry}) || managed != "" {
+		t.Fatalf("expected only the manual entry to be left, got %v, managing %q", entries, managed)
+	}
+}
+
+func TestOnChangeEnqueuesSync(t *testing.T) {
+	h, _ := newHandler()
+	enqueued := 0
+	h.enqueue = func(name string) {
+		if name != syncKey {
+			t.Fatalf("unexpected key %s", name)
+		}
+		enqueued++
+	}
+	expectEnqueued := func(expected int) {
+		t.Helper()
+		if enqueued != expected {
+			t.Fatalf("expected the sync to be enqueued %d times, got %d", expected, enqueued)
+		}
+	}
+	claimed := newDevice("node1", "0000:01:00.0", 0x10de, 0x1eb8)
+	unclaimed := newDevice("node1", "0000:02:00.0", 0x10de, 0x1eb8)
+	claim := newClaim("node1", "0000:01:00.0")
+	onDeviceChange := func(pd *v1beta1.PCIDevice) {
+		if _, err := h.OnDeviceChange(pd.Name, pd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Devices aren't synced before they're claimed
+	onDeviceChange(claimed)
+	onDeviceChange(unclaimed)
+	expectEnqueued(0)
+	if _, err := h.OnClaimChange(claim.Name, claim); err != nil {
+		t.Fatal(err)
+	}
+	expectEnqueued(1)
+
+	// Status refreshes and changes of unclaimed devices are ignored
+	claimed.Status.KernelDriverInUse = "vfio-pci"
+	onDeviceChange(claimed)
+	if _, err := h.OnClaimChange(claim.Name, claim); err != nil {
+		t.Fatal(err)
+	}
+	unclaimed.Status.ResourceName = ""
+	onDeviceChange(unclaimed)
+	expectEnqueued(1)
+
+	// A new resource name of a claimed device is synced, and so is the deleted claim
+	claimed.Status.ResourceName = ""
+	onDeviceChange(claimed)
+	expectEnqueued(2)
+	if _, err := h.OnClaimChange(claim.Name, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectEnqueued(3)
+}
@@ -0,0 +1,188 @@
+package sriovdevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/sysfs"
+)
+
+const (
+	// claimedRetryPeriod is how often an SRIOVDevice is retried while its VFs are claimed
+	claimedRetryPeriod = time.Second * 30
+)
+
+var (
+	errInvalidAddr = errors.New("invalid PF address")
+	errNotPF       = errors.New("device is not an SR-IOV physical function")
+	errTooManyVFs  = errors.New("more VFs requested than the device supports")
+	errVFsAreInUse = errors.New("VFs are claimed")
+)
+
+type Handler struct {
+	client       ctl.SRIOVDeviceClient
+	claimClient  ctl.PCIDeviceClaimClient
+	sysfs        *sysfs.SysFS
+	nodeName     string
+	enqueueAfter func(name string, duration time.Duration)
+}
+
+func Register(
+	ctx context.Context,
+	sd ctl.SRIOVDeviceController,
+	pdc ctl.PCIDeviceClaimClient,
+	fs *sysfs.SysFS,
+	nodeName string,
+) error {
+	logrus.Info("Registering SR-IOV Devices controller")
+	handler := &Handler{
+		client:       sd,
+		claimClient:  pdc,
+		sysfs:        fs,
+		nodeName:     nodeName,
+		enqueueAfter: sd.EnqueueAfter,
+	}
+	sd.OnChange(ctx, "sriovdevice-provision-vfs", handler.OnChange)
+	return nil
+}
+
+// OnChange creates or removes VFs on the PF of an SRIOVDevice of this node, until the PF
+// has the requested number of VFs. The new VFs are picked up by the PCI Devices controller.
+func (h *Handler) OnChange(key string, sd *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error) {
+	if sd == nil || sd.DeletionTimestamp != nil || sd.Spec.NodeName != h.nodeName {
+		return sd, nil
+	}
+	sdCopy := sd.DeepCopy()
+	provisionErr := h.provisionVFs(sdCopy)
+
+	condition := metav1.Condition{
+		Type:    v1beta1.SRIOVDeviceVFsProvisioned,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Provisioned",
+		Message: fmt.Sprintf("%d VFs enabled", sdCopy.Spec.NumVFs),
+	}
+	switch {
+	case errors.Is(provisionErr, errVFsAreInUse):
+		condition.Status, condition.Reason = metav1.ConditionFalse, "VFsClaimed"
+	case errors.Is(provisionErr, errInvalidAddr), errors.Is(provisionErr, errNotPF), errors.Is(provisionErr, errTooManyVFs):
+		condition.Status, condition.Reason = metav1.ConditionFalse, "InvalidSpec"
+	case provisionErr != nil:
+		condition.Status, condition.Reason = metav1.ConditionFalse, "Failed"
+	}
+	if provisionErr != nil {
+		condition.Message = provisionErr.Error()
+		logrus.Errorf("Failed to provision VFs for SR-IOV Device %s: %v", sd.Name, provisionErr)
+	}
+	if err := h.refreshStatus(sdCopy); err != nil {
+		return sd, err
+	}
+	meta.SetStatusCondition(&sdCopy.Status.Conditions, condition)
+
+	if !equality.Semantic.DeepEqual(sd.Status, sdCopy.Status) {
+		updated, err := h.client.UpdateStatus(sdCopy)
+		if err != nil {
+			return sd, err
+		}
+		sdCopy = updated
+	}
+
+	switch {
+	case errors.Is(provisionErr, errVFsAreInUse):
+		// Retry once the VFs are released
+		h.enqueueAfter(sd.Name, claimedRetryPeriod)
+		return sdCopy, nil
+	case errors.Is(provisionErr, errInvalidAddr), errors.Is(provisionErr, errNotPF), errors.Is(provisionErr, errTooManyVFs):
+		// Retrying won't help until the spec changes
+		return sdCopy, nil
+	}
+	return sdCopy, provisionErr
+}
+
+func (h *Handler) provisionVFs(sd *v1beta1.SRIOVDevice) error {
+	pciAddr, err := sd.Spec.PCIAddress()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidAddr, err)
+	}
+	addr := pciAddr.String()
+	totalVFs, err := h.sysfs.SRIOVTotalVFs(addr)
+	if err != nil {
+		return err
+	}
+	if totalVFs == 0 {
+		return fmt.Errorf("%w: %s", errNotPF, addr)
+	}
+	if sd.Spec.NumVFs < 0 || sd.Spec.NumVFs > totalVFs {
+		return fmt.Errorf("%w: %d requested, %s supports %d", errTooManyVFs, sd.Spec.NumVFs, addr, totalVFs)
+	}
+	numVFs, err := h.sysfs.SRIOVNumVFs(addr)
+	if err != nil {
+		return err
+	}
+	if numVFs == sd.Spec.NumVFs {
+		return nil
+	}
+	// Changing the number of VFs removes all the existing ones, so none of them may be claimed
+	if numVFs > 0 {
+		claimed, err := h.claimedVFs(addr)
+		if err != nil {
+			return err
+		}
+		if len(claimed) > 0 {
+			return fmt.Errorf("%w: %s", errVFsAreInUse, strings.Join(claimed, ", "))
+		}
+	}
+	logrus.Infof("Changing the number of VFs of %s from %d to %d", addr, numVFs, sd.Spec.NumVFs)
+	return h.sysfs.SetSRIOVNumVFs(addr, sd.Spec.NumVFs)
+}
+
+// claimedVFs returns the addresses of the VFs of the PF that have a PCIDeviceClaim
+func (h *Handler) claimedVFs(addr string) ([]string, error) {
+	vfs, err := h.sysfs.VirtFns(addr)
+	if err != nil {
+		return nil, err
+	}
+	pdcs, err := h.claimClient.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var claimedAddrs map[string]bool = make(map[string]bool)
+	for _, pdc := range pdcs.Items {
+		if pdc.Spec.NodeName == h.nodeName {
+			claimedAddrs[v1beta1.CanonicalPCIAddress(pdc.Spec.Address)] = true
+		}
+	}
+	var claimed []string
+	for _, vf := range vfs {
+		if claimedAddrs[vf] {
+			claimed = append(claimed, vf)
+		}
+	}
+	return claimed, nil
+}
+
+func (h *Handler) refreshStatus(sd *v1beta1.SRIOVDevice) error {
+	pciAddr, err := sd.Spec.PCIAddress()
+	if err != nil {
+		// The VFsProvisioned condition reports the invalid address
+		return nil
+	}
+	addr := pciAddr.String()
+	if sd.Status.TotalVFs, err = h.sysfs.SRIOVTotalVFs(addr); err != nil {
+		return err
+	}
+	if sd.Status.NumVFs, err = h.sysfs.SRIOVNumVFs(addr); err != nil {
+		return err
+	}
+	sd.Status.VFAddresses, err = h.sysfs.VirtFns(addr)
+	return err
+}
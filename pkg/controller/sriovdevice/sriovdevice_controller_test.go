@@ -0,0 +1,146 @@
+package sriovdevice
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/harvester/pcidevices/pkg/generated/clientset/versioned/fake"
+	"github.com/harvester/pcidevices/pkg/sysfs"
+	"github.com/harvester/pcidevices/pkg/sysfs/sysfstest"
+	"github.com/harvester/pcidevices/pkg/util/fakeclients"
+)
+
+var (
+	pf  = sysfstest.Device{Addr: "0000:22:00.0", Vendor: 0x8086, Device: 0x1557, Driver: "ixgbe"}
+	vf0 = sysfstest.Device{Addr: "0000:22:10.0", Vendor: 0x8086, Device: 0x10ed, Driver: "ixgbevf"}
+	vf1 = sysfstest.Device{Addr: "0000:22:10.2", Vendor: 0x8086, Device: 0x10ed, Driver: "ixgbevf"}
+)
+
+func newSRIOVDevice(nodeName string, numVFs int) *v1beta1.SRIOVDevice {
+	return &v1beta1.SRIOVDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1-ixgbe"},
+		Spec: v1beta1.SRIOVDeviceSpec{
+			Address:  pf.Addr,
+			NodeName: nodeName,
+			NumVFs:   numVFs,
+		},
+	}
+}
+
+func withAddress(sd *v1beta1.SRIOVDevice, addr string) *v1beta1.SRIOVDevice {
+	sd.Spec.Address = addr
+	return sd
+}
+
+func TestOnChange(t *testing.T) {
+	tests := []struct {
+		name            string
+		sd              *v1beta1.SRIOVDevice
+		claims          []string
+		expectedNumVFs  string
+		expectedReason  string
+		expectedRequeue bool
+	}{
+		{
+			name:           "VFs are created",
+			sd:             newSRIOVDevice("node1", 4),
+			expectedNumVFs: "4",
+			expectedReason: "Provisioned",
+		},
+		{
+			name:           "VFs are removed",
+			sd:             newSRIOVDevice("node1", 0),
+			expectedNumVFs: "0",
+			expectedReason: "Provisioned",
+		},
+		{
+			name:            "claimed VFs are not removed",
+			sd:              newSRIOVDevice("node1", 0),
+			claims:          []string{vf1.Addr},
+			expectedNumVFs:  "2",
+			expectedReason:  "VFsClaimed",
+			expectedRequeue: true,
+		},
+		{
+			name:           "more VFs than supported",
+			sd:             newSRIOVDevice("node1", 64),
+			expectedNumVFs: "2",
+			expectedReason: "InvalidSpec",
+		},
+		{
+			name:           "short address is canonicalized",
+			sd:             withAddress(newSRIOVDevice("node1", 4), "22:00.0"),
+			expectedNumVFs: "4",
+			expectedReason: "Provisioned",
+		},
+		{
+			name:           "invalid address",
+			sd:             withAddress(newSRIOVDevice("node1", 4), "0000:22:00"),
+			expectedNumVFs: "2",
+			expectedReason: "InvalidSpec",
+		},
+		{
+			name:           "other nodes are ignored",
+			sd:             newSRIOVDevice("node2", 4),
+			expectedNumVFs: "2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := sysfstest.NewTree(t)
+			tree.AddDevice(pf)
+			tree.AddVFs(pf, 8, vf0, vf1)
+			client := fake.NewSimpleClientset(tt.sd)
+			for _, addr := range tt.claims {
+				_, err := client.DevicesV1beta1().PCIDeviceClaims().Create(context.TODO(), &v1beta1.PCIDeviceClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "node1-" + addr},
+					Spec:       v1beta1.PCIDeviceClaimSpec{NodeName: "node1", Address: addr},
+				}, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			var requeued bool
+			h := &Handler{
+				client:       fakeclients.SRIOVDeviceClient(client.DevicesV1beta1().SRIOVDevices),
+				claimClient:  fakeclients.PCIDeviceClaimClient(client.DevicesV1beta1().PCIDeviceClaims),
+				sysfs:        sysfs.New(tree.Root, ""),
+				nodeName:     "node1",
+				enqueueAfter: func(string, time.Duration) { requeued = true },
+			}
+
+			sd, err := h.OnChange(tt.sd.Name, tt.sd)
+			if tt.expectedReason == "Provisioned" && err != nil {
+				t.Fatal(err)
+			}
+			numVFs, err := os.ReadFile(filepath.Join(tree.DevicesDir(), pf.Addr, "sriov_numvfs"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(string(numVFs)) != tt.expectedNumVFs {
+				t.Errorf("expected %s VFs, got %s", tt.expectedNumVFs, numVFs)
+			}
+			if requeued != tt.expectedRequeue {
+				t.Errorf("expected requeue to be %v", tt.expectedRequeue)
+			}
+			condition := meta.FindStatusCondition(sd.Status.Conditions, v1beta1.SRIOVDeviceVFsProvisioned)
+			if tt.expectedReason == "" {
+				if condition != nil {
+					t.Errorf("expected no condition, got %v", condition)
+				}
+				return
+			}
+			if condition == nil || condition.Reason != tt.expectedReason {
+				t.Errorf("expected condition reason %s, got %v", tt.expectedReason, condition)
+			}
+		})
+	}
+}
@@ -85,8 +85,9 @@ func List() []crd.CRD {
 				WithColumn("DeviceId", ".status.deviceId").
 				WithColumn("NodeName", ".status.nodeName").
 				WithColumn("Description", ".status.description").
-				WithColumn("KernelDriverInUse", ".kernelDriverInUse").
-				WithColumn("KernelModules", ".kernelModules")
+				WithColumn("KernelDriverInUse", ".status.kernelDriverInUse").
+				WithColumn("KernelModules", ".status.kernelModules").
+				WithColumn("IOMMUGroup", ".status.iommuGroup")
 		}),
 		newCRD(&devices.PCIDeviceClaim{}, func(c crd.CRD) crd.CRD {
 			c.NonNamespace = true
@@ -94,8 +95,19 @@ func List() []crd.CRD {
 				WithColumn("Address", ".spec.address").
 				WithColumn("NodeName", ".spec.nodeName").
 				WithColumn("UserName", ".spec.userName").
-				WithColumn("KernelDriverInUse", ".status.kernelDriverInUse").
-				WithColumn("PassthroughEnabled", ".status.passthroughEnabled")
+				WithColumn("KernelDriverToUnbind", ".status.kernelDriverToUnbind").
+				WithColumn("PassthroughEnabled", ".status.passthroughEnabled").
+				WithColumn("Phase", ".status.phase").
+				// The message of the condition that is holding the claim back
+				WithColumn("Message", `.status.conditions[?(@.status=="False")].message`)
+		}),
+		newCRD(&devices.SRIOVDevice{}, func(c crd.CRD) crd.CRD {
+			c.NonNamespace = true
+			return c.
+				WithColumn("Address", ".spec.address").
+				WithColumn("NodeName", ".spec.nodeName").
+				WithColumn("NumVFs", ".spec.numVFs").
+				WithColumn("EnabledVFs", ".status.numVFs")
 		}),
 	}
 }
@@ -0,0 +1,100 @@
+package deviceplugin
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reregisterPeriod is how often the manager checks whether kubelet restarted and
+// the plugins have to register again
+const reregisterPeriod = 5 * time.Second
+
+// Manager runs one Plugin per resource name that has devices on the node
+type Manager struct {
+	pluginDir string
+
+	mu      sync.Mutex
+	plugins map[string]*Plugin
+}
+
+func NewManager(pluginDir string) *Manager {
+	return &Manager{
+		pluginDir: pluginDir,
+		plugins:   map[string]*Plugin{},
+	}
+}
+
+// Sync starts plugins for new resource names, updates the devices of the running ones,
+// and stops the plugins of resource names that no longer have devices
+func (m *Manager) Sync(devices map[string][]Device) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var firstErr error
+	for resourceName, resourceDevices := range devices {
+		plugin, ok := m.plugins[resourceName]
+		if !ok {
+			plugin = NewPlugin(resourceName, m.pluginDir)
+			m.plugins[resourceName] = plugin
+		}
+		plugin.SetDevices(resourceDevices)
+		if plugin.Registered() {
+			continue
+		}
+		plugin.Stop()
+		if err := plugin.Start(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for resourceName, plugin := range m.plugins {
+		if _, ok := devices[resourceName]; ok {
+			continue
+		}
+		logrus.Infof("Stopping device plugin for %s", resourceName)
+		plugin.Stop()
+		delete(m.plugins, resourceName)
+	}
+	return firstErr
+}
+
+// Run restarts the plugins whose socket disappeared, which is what kubelet does when it restarts,
+// until ctx is done, and then stops all plugins
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(reregisterPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.stopAll()
+			return
+		case <-ticker.C:
+			m.reregister()
+		}
+	}
+}
+
+func (m *Manager) reregister() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for resourceName, plugin := range m.plugins {
+		if plugin.Registered() {
+			continue
+		}
+		logrus.Infof("Registering device plugin for %s again", resourceName)
+		plugin.Stop()
+		if err := plugin.Start(); err != nil {
+			logrus.Error(err)
+		}
+	}
+}
+
+func (m *Manager) stopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for resourceName, plugin := range m.plugins {
+		plugin.Stop()
+		delete(m.plugins, resourceName)
+	}
+}
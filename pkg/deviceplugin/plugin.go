@@ -0,0 +1,272 @@
+package deviceplugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const (
+	// vfioDevicePath is the vfio container device, which every user of a vfio group needs
+	vfioDevicePath = "/dev/vfio/vfio"
+	// envPrefix is what KubeVirt looks for to find the addresses of the allocated host devices
+	envPrefix = "PCI_RESOURCE"
+
+	dialTimeout = 5 * time.Second
+)
+
+// Device is a PCI device that is handed out to pods by a Plugin
+type Device struct {
+	// Address is the PCI address of the device, which is also its ID towards kubelet
+	Address    string
+	IOMMUGroup string
+	// NUMANode is -1 if the platform doesn't report one
+	NUMANode int
+	Healthy  bool
+}
+
+// Plugin serves the kubelet device plugin API for a single resource name
+type Plugin struct {
+	resourceName  string
+	socketPath    string
+	kubeletSocket string
+
+	mu      sync.Mutex
+	devices map[string]Device
+	// changed is closed and replaced whenever the devices change, to wake up ListAndWatch streams
+	changed chan struct{}
+
+	server *grpc.Server
+	stop   chan struct{}
+}
+
+// NewPlugin creates a plugin for resourceName that listens on a socket in pluginDir and
+// registers with the kubelet socket in the same directory
+func NewPlugin(resourceName string, pluginDir string) *Plugin {
+	return &Plugin{
+		resourceName:  resourceName,
+		socketPath:    filepath.Join(pluginDir, SocketName(resourceName)),
+		kubeletSocket: filepath.Join(pluginDir, filepath.Base(pluginapi.KubeletSocket)),
+		devices:       map[string]Device{},
+		changed:       make(chan struct{}),
+	}
+}
+
+// SocketName is the name of the socket the plugin for resourceName listens on
+func SocketName(resourceName string) string {
+	return "pcidevices-" + strings.NewReplacer("/", "-", ".", "-").Replace(resourceName) + ".sock"
+}
+
+// EnvName is the environment variable Allocate sets to the addresses of the devices, the
+// same way KubeVirt derives it from the resource name
+func EnvName(resourceName string) string {
+	name := strings.ToUpper(resourceName)
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, ".", "_")
+	return fmt.Sprintf("%s_%s", envPrefix, name)
+}
+
+// Start serves the device plugin API and registers with kubelet
+func (p *Plugin) Start() error {
+	if err := os.Remove(p.socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", p.socketPath)
+	if err != nil {
+		return err
+	}
+	p.server = grpc.NewServer()
+	p.stop = make(chan struct{})
+	pluginapi.RegisterDevicePluginServer(p.server, p)
+	go func() {
+		if err := p.server.Serve(listener); err != nil {
+			logrus.Errorf("device plugin for %s stopped serving: %v", p.resourceName, err)
+		}
+	}()
+
+	if err := p.register(); err != nil {
+		p.Stop()
+		return fmt.Errorf("error registering %s with kubelet: %w", p.resourceName, err)
+	}
+	logrus.Infof("Registered device plugin for %s", p.resourceName)
+	return nil
+}
+
+// Stop stops serving and removes the socket, after which kubelet considers the resource gone
+func (p *Plugin) Stop() {
+	if p.server == nil {
+		return
+	}
+	close(p.stop)
+	p.server.Stop()
+	p.server = nil
+	if err := os.Remove(p.socketPath); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("error removing %s: %v", p.socketPath, err)
+	}
+}
+
+// Registered is false once kubelet has restarted, which removes all plugin sockets
+func (p *Plugin) Registered() bool {
+	_, err := os.Stat(p.socketPath)
+	return p.server != nil && err == nil
+}
+
+func (p *Plugin) register() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err := dial(ctx, p.kubeletSocket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = pluginapi.NewRegistrationClient(conn).Register(ctx, &pluginapi.RegisterRequest{
+		Version:      pluginapi.Version,
+		Endpoint:     filepath.Base(p.socketPath),
+		ResourceName: p.resourceName,
+		Options:      &pluginapi.DevicePluginOptions{},
+	})
+	return err
+}
+
+// SetDevices replaces the devices that are advertised to kubelet
+func (p *Plugin) SetDevices(devices []Device) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := make(map[string]Device, len(devices))
+	for _, d := range devices {
+		next[d.Address] = d
+	}
+	if equalDevices(p.devices, next) {
+		return
+	}
+	p.devices = next
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func equalDevices(a, b map[string]Device) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for addr, d := range a {
+		if other, ok := b[addr]; !ok || other != d {
+			return false
+		}
+	}
+	return true
+}
+
+// list returns the devices in kubelet's format, along with a channel that is closed when they change
+func (p *Plugin) list() ([]*pluginapi.Device, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]*pluginapi.Device, 0, len(p.devices))
+	for _, d := range p.devices {
+		device := &pluginapi.Device{ID: d.Address, Health: pluginapi.Unhealthy}
+		if d.Healthy {
+			device.Health = pluginapi.Healthy
+		}
+		if d.NUMANode >= 0 {
+			device.Topology = &pluginapi.TopologyInfo{Nodes: []*pluginapi.NUMANode{{ID: int64(d.NUMANode)}}}
+		}
+		result = append(result, device)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, p.changed
+}
+
+func (p *Plugin) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
+	return &pluginapi.DevicePluginOptions{}, nil
+}
+
+func (p *Plugin) ListAndWatch(_ *pluginapi.Empty, stream pluginapi.DevicePlugin_ListAndWatchServer) error {
+	stop := p.stop
+	for {
+		devices, changed := p.list()
+		if err := stream.Send(&pluginapi.ListAndWatchResponse{Devices: devices}); err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-stop:
+			return nil
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (p *Plugin) GetPreferredAllocation(context.Context, *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+	return &pluginapi.PreferredAllocationResponse{}, nil
+}
+
+// Allocate gives the containers access to the vfio groups of the devices, and tells KubeVirt
+// which addresses it got through the PCI_RESOURCE_ environment variable
+func (p *Plugin) Allocate(_ context.Context, req *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	response := &pluginapi.AllocateResponse{}
+	for _, containerReq := range req.ContainerRequests {
+		containerResponse := &pluginapi.ContainerAllocateResponse{
+			Devices: []*pluginapi.DeviceSpec{{
+				HostPath:      vfioDevicePath,
+				ContainerPath: vfioDevicePath,
+				Permissions:   "mrw",
+			}},
+		}
+		groups := map[string]bool{}
+		for _, id := range containerReq.DevicesIDs {
+			d, ok := p.devices[id]
+			if !ok {
+				return nil, fmt.Errorf("%s is not a %s device", id, p.resourceName)
+			}
+			if !d.Healthy {
+				return nil, fmt.Errorf("%s is unhealthy", id)
+			}
+			if d.IOMMUGroup == "" {
+				return nil, fmt.Errorf("%s is not in an IOMMU group", id)
+			}
+			if groups[d.IOMMUGroup] {
+				continue
+			}
+			groups[d.IOMMUGroup] = true
+			groupPath := filepath.Join("/dev/vfio", d.IOMMUGroup)
+			containerResponse.Devices = append(containerResponse.Devices, &pluginapi.DeviceSpec{
+				HostPath:      groupPath,
+				ContainerPath: groupPath,
+				Permissions:   "mrw",
+			})
+		}
+		containerResponse.Envs = map[string]string{
+			EnvName(p.resourceName): strings.Join(containerReq.DevicesIDs, ","),
+		}
+		response.ContainerResponses = append(response.ContainerResponses, containerResponse)
+	}
+	return response, nil
+}
+
+func (p *Plugin) PreStartContainer(context.Context, *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
+
+func dial(ctx context.Context, socketPath string) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, socketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", addr)
+		}),
+	)
+}
@@ -0,0 +1,140 @@
+package deviceplugin
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+const resourceName = "pcidevices.harvesterhci.io/10de-1eb8"
+
+// fakeKubelet serves the kubelet registration socket and records the requests
+type fakeKubelet struct {
+	pluginapi.UnimplementedRegistrationServer
+	requests chan *pluginapi.RegisterRequest
+}
+
+func (k *fakeKubelet) Register(_ context.Context, req *pluginapi.RegisterRequest) (*pluginapi.Empty, error) {
+	k.requests <- req
+	return &pluginapi.Empty{}, nil
+}
+
+func startFakeKubelet(t *testing.T, dir string) *fakeKubelet {
+	listener, err := net.Listen("unix", filepath.Join(dir, "kubelet.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubelet := &fakeKubelet{requests: make(chan *pluginapi.RegisterRequest, 10)}
+	server := grpc.NewServer()
+	pluginapi.RegisterRegistrationServer(server, kubelet)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return kubelet
+}
+
+// startPlugin starts a plugin that registers with a fake kubelet, and returns a client of the plugin
+func startPlugin(t *testing.T, devices ...Device) (*Plugin, pluginapi.DevicePluginClient) {
+	dir := t.TempDir()
+	kubelet := startFakeKubelet(t, dir)
+	plugin := NewPlugin(resourceName, dir)
+	plugin.SetDevices(devices)
+	if err := plugin.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(plugin.Stop)
+
+	select {
+	case req := <-kubelet.requests:
+		expected := &pluginapi.RegisterRequest{
+			Version:      pluginapi.Version,
+			Endpoint:     "pcidevices-pcidevices-harvesterhci-io-10de-1eb8.sock",
+			ResourceName: resourceName,
+			Options:      &pluginapi.DevicePluginOptions{},
+		}
+		if !reflect.DeepEqual(req, expected) {
+			t.Fatalf("expected registration %v, got %v", expected, req)
+		}
+	default:
+		t.Fatal("expected the plugin to register with kubelet")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	conn, err := dial(ctx, filepath.Join(dir, "pcidevices-pcidevices-harvesterhci-io-10de-1eb8.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return plugin, pluginapi.NewDevicePluginClient(conn)
+}
+
+func TestListAndWatch(t *testing.T) {
+	gpu := Device{Address: "0000:01:00.0", IOMMUGroup: "13", NUMANode: 0, Healthy: true}
+	plugin, client := startPlugin(t, gpu)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Devices) != 1 || resp.Devices[0].ID != gpu.Address || resp.Devices[0].Health != pluginapi.Healthy ||
+		resp.Devices[0].Topology.Nodes[0].ID != 0 {
+		t.Fatalf("unexpected devices %v", resp.Devices)
+	}
+
+	// Changes are streamed to kubelet
+	plugin.SetDevices([]Device{gpu, {Address: "0000:02:00.0", IOMMUGroup: "14", NUMANode: -1, Healthy: true}})
+	resp, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Devices) != 2 || resp.Devices[1].ID != "0000:02:00.0" || resp.Devices[1].Topology != nil {
+		t.Fatalf("unexpected devices %v", resp.Devices)
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	gpu := Device{Address: "0000:01:00.0", IOMMUGroup: "13", NUMANode: -1, Healthy: true}
+	gpuAudio := Device{Address: "0000:01:00.1", IOMMUGroup: "13", NUMANode: -1, Healthy: true}
+	_, client := startPlugin(t, gpu, gpuAudio)
+
+	resp, err := client.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{gpu.Address, gpuAudio.Address}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ContainerResponses) != 1 {
+		t.Fatalf("expected 1 container response, got %v", resp.ContainerResponses)
+	}
+	container := resp.ContainerResponses[0]
+	expectedEnvs := map[string]string{"PCI_RESOURCE_PCIDEVICES_HARVESTERHCI_IO_10DE-1EB8": "0000:01:00.0,0000:01:00.1"}
+	if !reflect.DeepEqual(container.Envs, expectedEnvs) {
+		t.Errorf("expected envs %v, got %v", expectedEnvs, container.Envs)
+	}
+	var paths []string
+	for _, d := range container.Devices {
+		paths = append(paths, d.HostPath)
+	}
+	// Both functions share a group, which is only passed once
+	if !reflect.DeepEqual(paths, []string{"/dev/vfio/vfio", "/dev/vfio/13"}) {
+		t.Errorf("unexpected device specs %v", paths)
+	}
+
+	if _, err := client.Allocate(context.Background(), &pluginapi.AllocateRequest{
+		ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"0000:02:00.0"}}},
+	}); err == nil {
+		t.Fatal("expected an error allocating an unknown device")
+	}
+}
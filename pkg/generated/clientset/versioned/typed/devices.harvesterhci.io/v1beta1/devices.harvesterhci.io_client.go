@@ -30,6 +30,7 @@ type DevicesV1beta1Interface interface {
 	RESTClient() rest.Interface
 	PCIDevicesGetter
 	PCIDeviceClaimsGetter
+	SRIOVDevicesGetter
 }
 
 // DevicesV1beta1Client is used to interact with features provided by the devices.harvesterhci.io group.
@@ -45,6 +46,10 @@ func (c *DevicesV1beta1Client) PCIDeviceClaims() PCIDeviceClaimInterface {
 	return newPCIDeviceClaims(c)
 }
 
+func (c *DevicesV1beta1Client) SRIOVDevices() SRIOVDeviceInterface {
+	return newSRIOVDevices(c)
+}
+
 // NewForConfig creates a new DevicesV1beta1Client for the given config.
 // NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
 // where httpClient was generated with rest.HTTPClientFor(c).
@@ -36,6 +36,10 @@ func (c *FakeDevicesV1beta1) PCIDeviceClaims() v1beta1.PCIDeviceClaimInterface {
 	return &FakePCIDeviceClaims{c}
 }
 
+func (c *FakeDevicesV1beta1) SRIOVDevices() v1beta1.SRIOVDeviceInterface {
+	return &FakeSRIOVDevices{c}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeDevicesV1beta1) RESTClient() rest.Interface {
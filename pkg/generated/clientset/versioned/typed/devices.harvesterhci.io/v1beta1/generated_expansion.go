@@ -21,3 +21,5 @@ package v1beta1
 type PCIDeviceExpansion interface{}
 
 type PCIDeviceClaimExpansion interface{}
+
+type SRIOVDeviceExpansion interface{}
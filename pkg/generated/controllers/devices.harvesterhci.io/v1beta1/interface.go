@@ -32,6 +32,7 @@ func init() {
 type Interface interface {
 	PCIDevice() PCIDeviceController
 	PCIDeviceClaim() PCIDeviceClaimController
+	SRIOVDevice() SRIOVDeviceController
 }
 
 func New(controllerFactory controller.SharedControllerFactory) Interface {
@@ -50,3 +51,6 @@ func (c *version) PCIDevice() PCIDeviceController {
 func (c *version) PCIDeviceClaim() PCIDeviceClaimController {
 	return NewPCIDeviceClaimController(schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "PCIDeviceClaim"}, "pcideviceclaims", false, c.controllerFactory)
 }
+func (c *version) SRIOVDevice() SRIOVDeviceController {
+	return NewSRIOVDeviceController(schema.GroupVersionKind{Group: "devices.harvesterhci.io", Version: "v1beta1", Kind: "SRIOVDevice"}, "sriovdevices", false, c.controllerFactory)
+}
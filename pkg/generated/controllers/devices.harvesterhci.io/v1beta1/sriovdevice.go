@@ -0,0 +1,376 @@
+/*
+Copyright 2022 Rancher Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by main. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"context"
+	"time"
+
+	v1beta1 "github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	"github.com/rancher/lasso/pkg/client"
+	"github.com/rancher/lasso/pkg/controller"
+	"github.com/rancher/wrangler/pkg/apply"
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/generic"
+	"github.com/rancher/wrangler/pkg/kv"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+type SRIOVDeviceHandler func(string, *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error)
+
+type SRIOVDeviceController interface {
+	generic.ControllerMeta
+	SRIOVDeviceClient
+
+	OnChange(ctx context.Context, name string, sync SRIOVDeviceHandler)
+	OnRemove(ctx context.Context, name string, sync SRIOVDeviceHandler)
+	Enqueue(name string)
+	EnqueueAfter(name string, duration time.Duration)
+
+	Cache() SRIOVDeviceCache
+}
+
+type SRIOVDeviceClient interface {
+	Create(*v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error)
+	Update(*v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error)
+	UpdateStatus(*v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error)
+	Delete(name string, options *metav1.DeleteOptions) error
+	Get(name string, options metav1.GetOptions) (*v1beta1.SRIOVDevice, error)
+	List(opts metav1.ListOptions) (*v1beta1.SRIOVDeviceList, error)
+	Watch(opts metav1.ListOptions) (watch.Interface, error)
+	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.SRIOVDevice, err error)
+}
+
+type SRIOVDeviceCache interface {
+	Get(name string) (*v1beta1.SRIOVDevice, error)
+	List(selector labels.Selector) ([]*v1beta1.SRIOVDevice, error)
+
+	AddIndexer(indexName string, indexer SRIOVDeviceIndexer)
+	GetByIndex(indexName, key string) ([]*v1beta1.SRIOVDevice, error)
+}
+
+type SRIOVDeviceIndexer func(obj *v1beta1.SRIOVDevice) ([]string, error)
+
+type sRIOVDeviceController struct {
+	controller    controller.SharedController
+	client        *client.Client
+	gvk           schema.GroupVersionKind
+	groupResource schema.GroupResource
+}
+
+func NewSRIOVDeviceController(gvk schema.GroupVersionKind, resource string, namespaced bool, controller controller.SharedControllerFactory) SRIOVDeviceController {
+	c := controller.ForResourceKind(gvk.GroupVersion().WithResource(resource), gvk.Kind, namespaced)
+	return &sRIOVDeviceController{
+		controller: c,
+		client:     c.Client(),
+		gvk:        gvk,
+		groupResource: schema.GroupResource{
+			Group:    gvk.Group,
+			Resource: resource,
+		},
+	}
+}
+
+func FromSRIOVDeviceHandlerToHandler(sync SRIOVDeviceHandler) generic.Handler {
+	return func(key string, obj runtime.Object) (ret runtime.Object, err error) {
+		var v *v1beta1.SRIOVDevice
+		if obj == nil {
+			v, err = sync(key, nil)
+		} else {
+			v, err = sync(key, obj.(*v1beta1.SRIOVDevice))
+		}
+		if v == nil {
+			return nil, err
+		}
+		return v, err
+	}
+}
+
+func (c *sRIOVDeviceController) Updater() generic.Updater {
+	return func(obj runtime.Object) (runtime.Object, error) {
+		newObj, err := c.Update(obj.(*v1beta1.SRIOVDevice))
+		if newObj == nil {
+			return nil, err
+		}
+		return newObj, err
+	}
+}
+
+func UpdateSRIOVDeviceDeepCopyOnChange(client SRIOVDeviceClient, obj *v1beta1.SRIOVDevice, handler func(obj *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error)) (*v1beta1.SRIOVDevice, error) {
+	if obj == nil {
+		return obj, nil
+	}
+
+	copyObj := obj.DeepCopy()
+	newObj, err := handler(copyObj)
+	if newObj != nil {
+		copyObj = newObj
+	}
+	if obj.ResourceVersion == copyObj.ResourceVersion && !equality.Semantic.DeepEqual(obj, copyObj) {
+		return client.Update(copyObj)
+	}
+
+	return copyObj, err
+}
+
+func (c *sRIOVDeviceController) AddGenericHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.controller.RegisterHandler(ctx, name, controller.SharedControllerHandlerFunc(handler))
+}
+
+func (c *sRIOVDeviceController) AddGenericRemoveHandler(ctx context.Context, name string, handler generic.Handler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), handler))
+}
+
+func (c *sRIOVDeviceController) OnChange(ctx context.Context, name string, sync SRIOVDeviceHandler) {
+	c.AddGenericHandler(ctx, name, FromSRIOVDeviceHandlerToHandler(sync))
+}
+
+func (c *sRIOVDeviceController) OnRemove(ctx context.Context, name string, sync SRIOVDeviceHandler) {
+	c.AddGenericHandler(ctx, name, generic.NewRemoveHandler(name, c.Updater(), FromSRIOVDeviceHandlerToHandler(sync)))
+}
+
+func (c *sRIOVDeviceController) Enqueue(name string) {
+	c.controller.Enqueue("", name)
+}
+
+func (c *sRIOVDeviceController) EnqueueAfter(name string, duration time.Duration) {
+	c.controller.EnqueueAfter("", name, duration)
+}
+
+func (c *sRIOVDeviceController) Informer() cache.SharedIndexInformer {
+	return c.controller.Informer()
+}
+
+func (c *sRIOVDeviceController) GroupVersionKind() schema.GroupVersionKind {
+	return c.gvk
+}
+
+func (c *sRIOVDeviceController) Cache() SRIOVDeviceCache {
+	return &sRIOVDeviceCache{
+		indexer:  c.Informer().GetIndexer(),
+		resource: c.groupResource,
+	}
+}
+
+func (c *sRIOVDeviceController) Create(obj *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error) {
+	result := &v1beta1.SRIOVDevice{}
+	return result, c.client.Create(context.TODO(), "", obj, result, metav1.CreateOptions{})
+}
+
+func (c *sRIOVDeviceController) Update(obj *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error) {
+	result := &v1beta1.SRIOVDevice{}
+	return result, c.client.Update(context.TODO(), "", obj, result, metav1.UpdateOptions{})
+}
+
+func (c *sRIOVDeviceController) UpdateStatus(obj *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error) {
+	result := &v1beta1.SRIOVDevice{}
+	return result, c.client.UpdateStatus(context.TODO(), "", obj, result, metav1.UpdateOptions{})
+}
+
+func (c *sRIOVDeviceController) Delete(name string, options *metav1.DeleteOptions) error {
+	if options == nil {
+		options = &metav1.DeleteOptions{}
+	}
+	return c.client.Delete(context.TODO(), "", name, *options)
+}
+
+func (c *sRIOVDeviceController) Get(name string, options metav1.GetOptions) (*v1beta1.SRIOVDevice, error) {
+	result := &v1beta1.SRIOVDevice{}
+	return result, c.client.Get(context.TODO(), "", name, result, options)
+}
+
+func (c *sRIOVDeviceController) List(opts metav1.ListOptions) (*v1beta1.SRIOVDeviceList, error) {
+	result := &v1beta1.SRIOVDeviceList{}
+	return result, c.client.List(context.TODO(), "", result, opts)
+}
+
+func (c *sRIOVDeviceController) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return c.client.Watch(context.TODO(), "", opts)
+}
+
+func (c *sRIOVDeviceController) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*v1beta1.SRIOVDevice, error) {
+	result := &v1beta1.SRIOVDevice{}
+	return result, c.client.Patch(context.TODO(), "", name, pt, data, result, metav1.PatchOptions{}, subresources...)
+}
+
+type sRIOVDeviceCache struct {
+	indexer  cache.Indexer
+	resource schema.GroupResource
+}
+
+func (c *sRIOVDeviceCache) Get(name string) (*v1beta1.SRIOVDevice, error) {
+	obj, exists, err := c.indexer.GetByKey(name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.NewNotFound(c.resource, name)
+	}
+	return obj.(*v1beta1.SRIOVDevice), nil
+}
+
+func (c *sRIOVDeviceCache) List(selector labels.Selector) (ret []*v1beta1.SRIOVDevice, err error) {
+
+	err = cache.ListAll(c.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1beta1.SRIOVDevice))
+	})
+
+	return ret, err
+}
+
+func (c *sRIOVDeviceCache) AddIndexer(indexName string, indexer SRIOVDeviceIndexer) {
+	utilruntime.Must(c.indexer.AddIndexers(map[string]cache.IndexFunc{
+		indexName: func(obj interface{}) (strings []string, e error) {
+			return indexer(obj.(*v1beta1.SRIOVDevice))
+		},
+	}))
+}
+
+func (c *sRIOVDeviceCache) GetByIndex(indexName, key string) (result []*v1beta1.SRIOVDevice, err error) {
+	objs, err := c.indexer.ByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	result = make([]*v1beta1.SRIOVDevice, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(*v1beta1.SRIOVDevice))
+	}
+	return result, nil
+}
+
+type SRIOVDeviceStatusHandler func(obj *v1beta1.SRIOVDevice, status v1beta1.SRIOVDeviceStatus) (v1beta1.SRIOVDeviceStatus, error)
+
+type SRIOVDeviceGeneratingHandler func(obj *v1beta1.SRIOVDevice, status v1beta1.SRIOVDeviceStatus) ([]runtime.Object, v1beta1.SRIOVDeviceStatus, error)
+
+func RegisterSRIOVDeviceStatusHandler(ctx context.Context, controller SRIOVDeviceController, condition condition.Cond, name string, handler SRIOVDeviceStatusHandler) {
+	statusHandler := &sRIOVDeviceStatusHandler{
+		client:    controller,
+		condition: condition,
+		handler:   handler,
+	}
+	controller.AddGenericHandler(ctx, name, FromSRIOVDeviceHandlerToHandler(statusHandler.sync))
+}
+
+func RegisterSRIOVDeviceGeneratingHandler(ctx context.Context, controller SRIOVDeviceController, apply apply.Apply,
+	condition condition.Cond, name string, handler SRIOVDeviceGeneratingHandler, opts *generic.GeneratingHandlerOptions) {
+	statusHandler := &sRIOVDeviceGeneratingHandler{
+		SRIOVDeviceGeneratingHandler: handler,
+		apply:                        apply,
+		name:                         name,
+		gvk:                          controller.GroupVersionKind(),
+	}
+	if opts != nil {
+		statusHandler.opts = *opts
+	}
+	controller.OnChange(ctx, name, statusHandler.Remove)
+	RegisterSRIOVDeviceStatusHandler(ctx, controller, condition, name, statusHandler.Handle)
+}
+
+type sRIOVDeviceStatusHandler struct {
+	client    SRIOVDeviceClient
+	condition condition.Cond
+	handler   SRIOVDeviceStatusHandler
+}
+
+func (a *sRIOVDeviceStatusHandler) sync(key string, obj *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error) {
+	if obj == nil {
+		return obj, nil
+	}
+
+	origStatus := obj.Status.DeepCopy()
+	obj = obj.DeepCopy()
+	newStatus, err := a.handler(obj, obj.Status)
+	if err != nil {
+		// Revert to old status on error
+		newStatus = *origStatus.DeepCopy()
+	}
+
+	if a.condition != "" {
+		if errors.IsConflict(err) {
+			a.condition.SetError(&newStatus, "", nil)
+		} else {
+			a.condition.SetError(&newStatus, "", err)
+		}
+	}
+	if !equality.Semantic.DeepEqual(origStatus, &newStatus) {
+		if a.condition != "" {
+			// Since status has changed, update the lastUpdatedTime
+			a.condition.LastUpdated(&newStatus, time.Now().UTC().Format(time.RFC3339))
+		}
+
+		var newErr error
+		obj.Status = newStatus
+		newObj, newErr := a.client.UpdateStatus(obj)
+		if err == nil {
+			err = newErr
+		}
+		if newErr == nil {
+			obj = newObj
+		}
+	}
+	return obj, err
+}
+
+type sRIOVDeviceGeneratingHandler struct {
+	SRIOVDeviceGeneratingHandler
+	apply apply.Apply
+	opts  generic.GeneratingHandlerOptions
+	gvk   schema.GroupVersionKind
+	name  string
+}
+
+func (a *sRIOVDeviceGeneratingHandler) Remove(key string, obj *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error) {
+	if obj != nil {
+		return obj, nil
+	}
+
+	obj = &v1beta1.SRIOVDevice{}
+	obj.Namespace, obj.Name = kv.RSplit(key, "/")
+	obj.SetGroupVersionKind(a.gvk)
+
+	return nil, generic.ConfigureApplyForObject(a.apply, obj, &a.opts).
+		WithOwner(obj).
+		WithSetID(a.name).
+		ApplyObjects()
+}
+
+func (a *sRIOVDeviceGeneratingHandler) Handle(obj *v1beta1.SRIOVDevice, status v1beta1.SRIOVDeviceStatus) (v1beta1.SRIOVDeviceStatus, error) {
+	if !obj.DeletionTimestamp.IsZero() {
+		return status, nil
+	}
+
+	objs, newStatus, err := a.SRIOVDeviceGeneratingHandler(obj, status)
+	if err != nil {
+		return newStatus, err
+	}
+
+	return newStatus, generic.ConfigureApplyForObject(a.apply, obj, &a.opts).
+		WithOwner(obj).
+		WithSetID(a.name).
+		ApplyObjects(objs...)
+}
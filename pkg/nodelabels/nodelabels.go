@@ -0,0 +1,144 @@
+// The nodelabels module summarizes the PCI devices of a node in labels and an annotation
+// on its Node, so that VMs can be scheduled with nodeSelectors and affinity rules
+// without querying PCIDevices.
+
+package nodelabels
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/pci"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	prefix = "pcidevices.harvesterhci.io/"
+	// GPUPresentLabel is set to "true" on nodes with a display controller, i.e. class 03
+	GPUPresentLabel = prefix + "gpu.present"
+	// DevicesAnnotation holds the number of devices of the node per vendor:device, as JSON
+	DevicesAnnotation = prefix + "devices"
+	// Wildcard selects every class or every device
+	Wildcard = "*"
+)
+
+var (
+	managedLabelPattern = regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `(gpu\.present|class-[0-9a-f]+\.count|device-[0-9a-f]{4}-[0-9a-f]{4}\.count)$`)
+	classPattern        = regexp.MustCompile(`^([0-9a-f]{2}|[0-9a-f]{4})$`)
+	devicePattern       = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{4}$`)
+)
+
+// Config selects the labels that are published, so that node metadata isn't flooded
+type Config struct {
+	// GPU publishes GPUPresentLabel
+	GPU bool
+	// Classes publishes a count label per class, e.g. "03" for all display controllers,
+	// or "0302" for 3D controllers only
+	Classes []string
+	// Devices publishes a count label per vendor:device, e.g. "10de:1eb8"
+	Devices []string
+}
+
+// DefaultConfig only publishes GPUPresentLabel
+var DefaultConfig = Config{GPU: true}
+
+// ParseConfig parses a list of label selectors: "gpu", "class:<class>" and "device:<vendor>:<device>",
+// where class or vendor:device may be "*" to publish a label for every class or device on the node.
+func ParseConfig(selectors []string) (Config, error) {
+	var config Config
+	for _, selector := range selectors {
+		selector = strings.ToLower(strings.TrimSpace(selector))
+		kind, value, _ := strings.Cut(selector, ":")
+		switch {
+		case selector == "":
+			continue
+		case kind == "gpu" && value == "":
+			config.GPU = true
+		case kind == "class" && (value == Wildcard || classPattern.MatchString(value)):
+			config.Classes = append(config.Classes, value)
+		case kind == "device" && (value == Wildcard || devicePattern.MatchString(value)):
+			config.Devices = append(config.Devices, value)
+		default:
+			return config, fmt.Errorf("invalid node label selector %q: expected gpu, class:<class> or device:<vendor>:<device>", selector)
+		}
+	}
+	return config, nil
+}
+
+// Labels returns the labels selected by the config for the devices
+func (c Config) Labels(devices []*pci.PCI) map[string]string {
+	labels := map[string]string{}
+	classCounts := map[string]int{}
+	deviceCounts := map[string]int{}
+	for _, dev := range devices {
+		if c.GPU && dev.Class>>16 == 0x03 {
+			labels[GPUPresentLabel] = "true"
+		}
+		for _, class := range c.Classes {
+			switch {
+			case class == Wildcard:
+				classCounts[fmt.Sprintf("%04x", dev.Class>>8)]++
+			case len(class) == 2 && fmt.Sprintf("%02x", dev.Class>>16) == class:
+				classCounts[class]++
+			case len(class) == 4 && fmt.Sprintf("%04x", dev.Class>>8) == class:
+				classCounts[class]++
+			}
+		}
+		id := fmt.Sprintf("%04x:%04x", dev.Vendor, dev.Device)
+		for _, device := range c.Devices {
+			if device == Wildcard || device == id {
+				deviceCounts[id]++
+			}
+		}
+	}
+	for class, count := range classCounts {
+		labels[fmt.Sprintf("%sclass-%s.count", prefix, class)] = strconv.Itoa(count)
+	}
+	for id, count := range deviceCounts {
+		labels[fmt.Sprintf("%sdevice-%s.count", prefix, strings.Replace(id, ":", "-", 1))] = strconv.Itoa(count)
+	}
+	return labels
+}
+
+// DevicesSummary returns the value of DevicesAnnotation for the devices
+func DevicesSummary(devices []*pci.PCI) (string, error) {
+	counts := map[string]int{}
+	for _, dev := range devices {
+		counts[fmt.Sprintf("%04x:%04x", dev.Vendor, dev.Device)]++
+	}
+	// Map keys are marshalled in order, so the summary only changes when the devices do
+	summary, err := json.Marshal(counts)
+	return string(summary), err
+}
+
+// Update sets the labels and the devices annotation on the node, and removes the labels
+// it manages that are no longer selected. It returns true if the node changed.
+func Update(node *corev1.Node, labels map[string]string, summary string) bool {
+	changed := false
+	for key := range node.Labels {
+		if _, found := labels[key]; !found && managedLabelPattern.MatchString(key) {
+			delete(node.Labels, key)
+			changed = true
+		}
+	}
+	for key, value := range labels {
+		if node.Labels[key] != value {
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			node.Labels[key] = value
+			changed = true
+		}
+	}
+	if node.Annotations[DevicesAnnotation] != summary {
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		node.Annotations[DevicesAnnotation] = summary
+		changed = true
+	}
+	return changed
+}
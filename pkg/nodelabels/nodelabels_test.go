@@ -0,0 +1,90 @@
+package nodelabels
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/pci"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var devices = []*pci.PCI{
+	{Vendor: 0x10de, Device: 0x1eb8, Class: 0x030200},
+	{Vendor: 0x10de, Device: 0x1eb8, Class: 0x030200},
+	{Vendor: 0x1a03, Device: 0x2000, Class: 0x030000},
+	{Vendor: 0x8086, Device: 0x1521, Class: 0x020000},
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig([]string{"gpu", "class:03", "class:0302", "device:10DE:1EB8", "device:*", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Config{GPU: true, Classes: []string{"03", "0302"}, Devices: []string{"10de:1eb8", "*"}}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, config)
+	}
+	for _, selector := range []string{"gpus", "class:030", "device:10de", "vendor:10de"} {
+		if _, err := ParseConfig([]string{selector}); err == nil {
+			t.Errorf("expected %q to be invalid", selector)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	config := Config{GPU: true, Classes: []string{"03", "0302"}, Devices: []string{"10de:1eb8"}}
+	expected := map[string]string{
+		"pcidevices.harvesterhci.io/gpu.present":            "true",
+		"pcidevices.harvesterhci.io/class-03.count":         "3",
+		"pcidevices.harvesterhci.io/class-0302.count":       "2",
+		"pcidevices.harvesterhci.io/device-10de-1eb8.count": "2",
+	}
+	if labels := config.Labels(devices); !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("expected %v, got %v", expected, labels)
+	}
+
+	config = Config{Classes: []string{Wildcard}}
+	expected = map[string]string{
+		"pcidevices.harvesterhci.io/class-0302.count": "2",
+		"pcidevices.harvesterhci.io/class-0300.count": "1",
+		"pcidevices.harvesterhci.io/class-0200.count": "1",
+	}
+	if labels := config.Labels(devices); !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("expected %v, got %v", expected, labels)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"kubernetes.io/hostname":                            "node1",
+		"pcidevices.harvesterhci.io/device-10de-1eb8.count": "2",
+		"pcidevices.harvesterhci.io/numa-node":              "0",
+	}}}
+	summary, err := DevicesSummary(devices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary != `{"10de:1eb8":2,"1a03:2000":1,"8086:1521":1}` {
+		t.Fatalf("unexpected summary %s", summary)
+	}
+	labels := DefaultConfig.Labels(devices)
+	if !Update(node, labels, summary) {
+		t.Fatal("expected the node to change")
+	}
+	expected := map[string]string{
+		"kubernetes.io/hostname":                 "node1",
+		"pcidevices.harvesterhci.io/gpu.present": "true",
+		// Labels that don't look like the ones it manages are left alone
+		"pcidevices.harvesterhci.io/numa-node": "0",
+	}
+	if !reflect.DeepEqual(node.Labels, expected) {
+		t.Fatalf("expected %v, got %v", expected, node.Labels)
+	}
+	if node.Annotations[DevicesAnnotation] != summary {
+		t.Fatalf("expected the devices annotation to be set, got %v", node.Annotations)
+	}
+	if Update(node, labels, summary) {
+		t.Fatal("expected the node to be unchanged")
+	}
+}
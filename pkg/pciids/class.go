@@ -0,0 +1,189 @@
+// The pciids module resolves PCI IDs to human readable names, like lspci does.
+
+package pciids
+
+type subclass struct {
+	name    string
+	progIfs map[uint8]string
+}
+
+type class struct {
+	name       string
+	subclasses map[uint8]subclass
+}
+
+// classes are the PCI class codes, named as in the class section of pci.ids
+var classes = map[uint8]class{
+	0x00: {"Unclassified device", map[uint8]subclass{
+		0x00: {name: "Non-VGA unclassified device"},
+		0x01: {name: "VGA compatible unclassified device"},
+		0x05: {name: "Image coprocessor"},
+	}},
+	0x01: {"Mass storage controller", map[uint8]subclass{
+		0x00: {name: "SCSI storage controller"},
+		0x01: {name: "IDE interface"},
+		0x02: {name: "Floppy disk controller"},
+		0x03: {name: "IPI bus controller"},
+		0x04: {name: "RAID bus controller"},
+		0x05: {"ATA controller", map[uint8]string{0x20: "ADMA single stepping", 0x30: "ADMA continuous operation"}},
+		0x06: {"SATA controller", map[uint8]string{0x00: "Vendor specific", 0x01: "AHCI 1.0", 0x02: "Serial Storage Bus"}},
+		0x07: {"Serial Attached SCSI controller", map[uint8]string{0x01: "Serial Storage Bus"}},
+		0x08: {"Non-Volatile memory controller", map[uint8]string{0x01: "NVMHCI", 0x02: "NVM Express"}},
+		0x09: {name: "Universal Flash Storage controller"},
+		0x80: {name: "Mass storage controller"},
+	}},
+	0x02: {"Network controller", map[uint8]subclass{
+		0x00: {name: "Ethernet controller"},
+		0x01: {name: "Token ring network controller"},
+		0x02: {name: "FDDI network controller"},
+		0x03: {name: "ATM network controller"},
+		0x04: {name: "ISDN controller"},
+		0x05: {name: "WorldFip controller"},
+		0x06: {name: "PICMG controller"},
+		0x07: {name: "Infiniband controller"},
+		0x08: {name: "Fabric controller"},
+		0x80: {name: "Network controller"},
+	}},
+	0x03: {"Display controller", map[uint8]subclass{
+		0x00: {"VGA compatible controller", map[uint8]string{0x00: "VGA controller", 0x01: "8514 controller"}},
+		0x01: {name: "XGA compatible controller"},
+		0x02: {name: "3D controller"},
+		0x80: {name: "Display controller"},
+	}},
+	0x04: {"Multimedia controller", map[uint8]subclass{
+		0x00: {name: "Multimedia video controller"},
+		0x01: {name: "Multimedia audio controller"},
+		0x02: {name: "Computer telephony device"},
+		0x03: {name: "Audio device"},
+		0x80: {name: "Multimedia controller"},
+	}},
+	0x05: {"Memory controller", map[uint8]subclass{
+		0x00: {name: "RAM memory"},
+		0x01: {name: "FLASH memory"},
+		0x02: {name: "CXL"},
+		0x80: {name: "Memory controller"},
+	}},
+	0x06: {"Bridge", map[uint8]subclass{
+		0x00: {name: "Host bridge"},
+		0x01: {name: "ISA bridge"},
+		0x02: {name: "EISA bridge"},
+		0x03: {name: "MicroChannel bridge"},
+		0x04: {"PCI bridge", map[uint8]string{0x00: "Normal decode", 0x01: "Subtractive decode"}},
+		0x05: {name: "PCMCIA bridge"},
+		0x06: {name: "NuBus bridge"},
+		0x07: {name: "CardBus bridge"},
+		0x08: {name: "RACEway bridge"},
+		0x09: {name: "Semi-transparent PCI-to-PCI bridge"},
+		0x0a: {name: "InfiniBand to PCI host bridge"},
+		0x80: {name: "Bridge"},
+	}},
+	0x07: {"Communication controller", map[uint8]subclass{
+		0x00: {name: "Serial controller"},
+		0x01: {name: "Parallel controller"},
+		0x02: {name: "Multiport serial controller"},
+		0x03: {name: "Modem"},
+		0x04: {name: "GPIB controller"},
+		0x05: {name: "Smard Card controller"},
+		0x80: {name: "Communication controller"},
+	}},
+	0x08: {"Generic system peripheral", map[uint8]subclass{
+		0x00: {name: "PIC"},
+		0x01: {name: "DMA controller"},
+		0x02: {name: "Timer"},
+		0x03: {name: "RTC"},
+		0x04: {name: "PCI Hot-plug controller"},
+		0x05: {name: "SD Host controller"},
+		0x06: {name: "IOMMU"},
+		0x80: {name: "System peripheral"},
+		0x99: {name: "Timing Card"},
+	}},
+	0x09: {"Input device controller", map[uint8]subclass{
+		0x00: {name: "Keyboard controller"},
+		0x01: {name: "Digitizer Pen"},
+		0x02: {name: "Mouse controller"},
+		0x03: {name: "Scanner controller"},
+		0x04: {name: "Gameport controller"},
+		0x80: {name: "Input device controller"},
+	}},
+	0x0a: {"Docking station", map[uint8]subclass{
+		0x00: {name: "Generic Docking Station"},
+		0x80: {name: "Docking Station"},
+	}},
+	0x0b: {"Processor", map[uint8]subclass{
+		0x00: {name: "386"},
+		0x01: {name: "486"},
+		0x02: {name: "Pentium"},
+		0x10: {name: "Alpha"},
+		0x20: {name: "Power PC"},
+		0x30: {name: "MIPS"},
+		0x40: {name: "Co-processor"},
+		0x80: {name: "Processor"},
+	}},
+	0x0c: {"Serial bus controller", map[uint8]subclass{
+		0x00: {name: "FireWire (IEEE 1394)"},
+		0x01: {name: "ACCESS Bus"},
+		0x02: {name: "SSA"},
+		0x03: {"USB controller", map[uint8]string{
+			0x00: "UHCI", 0x10: "OHCI", 0x20: "EHCI", 0x30: "XHCI", 0x40: "USB4 Host Interface", 0xfe: "USB Device",
+		}},
+		0x04: {name: "Fibre Channel"},
+		0x05: {name: "SMBus"},
+		0x06: {name: "InfiniBand"},
+		0x07: {name: "IPMI Interface"},
+		0x08: {name: "SERCOS interface"},
+		0x09: {name: "CANBUS"},
+		0x80: {name: "Serial bus controller"},
+	}},
+	0x0d: {"Wireless controller", map[uint8]subclass{
+		0x00: {name: "IRDA controller"},
+		0x01: {name: "Consumer IR controller"},
+		0x10: {name: "RF controller"},
+		0x11: {name: "Bluetooth"},
+		0x12: {name: "Broadband"},
+		0x20: {name: "802.1a controller"},
+		0x21: {name: "802.1b controller"},
+		0x80: {name: "Wireless controller"},
+	}},
+	0x0e: {"Intelligent controller", map[uint8]subclass{
+		0x00: {name: "I2O"},
+	}},
+	0x0f: {"Satellite communications controller", map[uint8]subclass{
+		0x01: {name: "Satellite TV controller"},
+		0x02: {name: "Satellite audio communication controller"},
+		0x03: {name: "Satellite voice communication controller"},
+		0x04: {name: "Satellite data communication controller"},
+	}},
+	0x10: {"Encryption controller", map[uint8]subclass{
+		0x00: {name: "Network and computing encryption device"},
+		0x10: {name: "Entertainment encryption device"},
+		0x80: {name: "Encryption controller"},
+	}},
+	0x11: {"Signal processing controller", map[uint8]subclass{
+		0x00: {name: "DPIO module"},
+		0x01: {name: "Performance counters"},
+		0x10: {name: "Communication synchronizer"},
+		0x20: {name: "Signal processing management"},
+		0x80: {name: "Signal processing controller"},
+	}},
+	0x12: {"Processing accelerators", map[uint8]subclass{
+		0x00: {name: "Processing accelerators"},
+		0x01: {name: "SNIA Smart Data Accelerator Interface (SDXI) controller"},
+	}},
+	0x13: {name: "Non-Essential Instrumentation"},
+	0x40: {name: "Coprocessor"},
+	0xff: {name: "Unassigned class"},
+}
+
+// ClassNames returns the names of the class, subclass and programming interface of a
+// 24-bit PCI class code. Names that are not known are returned empty.
+func ClassNames(code uint32) (className string, subclassName string, progIfName string) {
+	c, found := classes[uint8(code>>16)]
+	if !found {
+		return "", "", ""
+	}
+	s, found := c.subclasses[uint8(code>>8)]
+	if !found {
+		return c.name, "", ""
+	}
+	return c.name, s.name, s.progIfs[uint8(code)]
+}
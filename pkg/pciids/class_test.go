@@ -0,0 +1,26 @@
+package pciids
+
+import "testing"
+
+func TestClassNames(t *testing.T) {
+	tests := []struct {
+		code     uint32
+		class    string
+		subclass string
+		progIf   string
+	}{
+		{0x030000, "Display controller", "VGA compatible controller", "VGA controller"},
+		{0x040300, "Multimedia controller", "Audio device", ""},
+		{0x010802, "Mass storage controller", "Non-Volatile memory controller", "NVM Express"},
+		{0x0c0330, "Serial bus controller", "USB controller", "XHCI"},
+		{0x120000, "Processing accelerators", "Processing accelerators", ""},
+		{0x137700, "Non-Essential Instrumentation", "", ""},
+		{0x770000, "", "", ""},
+	}
+	for _, tt := range tests {
+		class, subclass, progIf := ClassNames(tt.code)
+		if class != tt.class || subclass != tt.subclass || progIf != tt.progIf {
+			t.Errorf("%06x: expected %q/%q/%q, got %q/%q/%q", tt.code, tt.class, tt.subclass, tt.progIf, class, subclass, progIf)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package pciids
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultPaths are where distributions install pci.ids. The image bundles it
+// from the hwdata-pci package, in the first location.
+var DefaultPaths = []string{
+	"/usr/share/hwdata/pci.ids",
+	"/usr/share/misc/pci.ids",
+	"/usr/share/pci.ids",
+}
+
+type device struct {
+	name       string
+	subsystems map[uint32]string
+}
+
+type vendor struct {
+	name    string
+	devices map[uint16]device
+}
+
+// DB is a parsed pci.ids database. A nil DB knows no names.
+type DB struct {
+	vendors map[uint16]vendor
+}
+
+// Load parses the first pci.ids database that exists at one of paths
+func Load(paths ...string) (*DB, error) {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		defer f.Close()
+		return Parse(f)
+	}
+	return nil, errors.New("pci.ids not found in " + strings.Join(paths, ", "))
+}
+
+// Parse reads the vendor section of a pci.ids database, which looks like:
+//
+//	8086  Intel Corporation
+//		1557  82599 10 Gigabit Network Connection
+//			1dcf 0317  10GbE SFP+ Dual Port Adapter
+func Parse(r io.Reader) (*DB, error) {
+	db := &DB{vendors: map[uint16]vendor{}}
+	// The devices of the vendor and the subsystems of the device being parsed, nil if the line
+	// they belong to is missing or couldn't be parsed
+	var devices map[uint16]device
+	var subsystems map[uint32]string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// The class section comes after all the vendors
+		if strings.HasPrefix(line, "C ") {
+			break
+		}
+		tabs := len(line) - len(strings.TrimLeft(line, "\t"))
+		id, name, found := strings.Cut(strings.TrimLeft(line, "\t"), "  ")
+		if !found {
+			continue
+		}
+		switch tabs {
+		case 0:
+			devices, subsystems = nil, nil
+			v, err := parseID(id)
+			if err != nil {
+				continue
+			}
+			devices = map[uint16]device{}
+			db.vendors[v] = vendor{name: name, devices: devices}
+		case 1:
+			subsystems = nil
+			d, err := parseID(id)
+			if err != nil || devices == nil {
+				continue
+			}
+			subsystems = map[uint32]string{}
+			devices[d] = device{name: name, subsystems: subsystems}
+		case 2:
+			if subsystems == nil {
+				continue
+			}
+			subVendor, subDevice, found := strings.Cut(id, " ")
+			if !found {
+				continue
+			}
+			sv, err := parseID(subVendor)
+			if err != nil {
+				continue
+			}
+			sd, err := parseID(subDevice)
+			if err != nil {
+				continue
+			}
+			subsystems[uint32(sv)<<16|uint32(sd)] = name
+		}
+	}
+	return db, scanner.Err()
+}
+
+func parseID(id string) (uint16, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(id), 16, 16)
+	return uint16(n), err
+}
+
+// VendorName returns the name of the vendor, or "" if it is unknown
+func (db *DB) VendorName(vendorId uint16) string {
+	if db == nil {
+		return ""
+	}
+	return db.vendors[vendorId].name
+}
+
+// DeviceName returns the name of the device, or "" if it is unknown
+func (db *DB) DeviceName(vendorId uint16, deviceId uint16) string {
+	if db == nil {
+		return ""
+	}
+	return db.vendors[vendorId].devices[deviceId].name
+}
+
+// SubsystemName returns the name of the subsystem of the device, or "" if it is unknown
+func (db *DB) SubsystemName(vendorId uint16, deviceId uint16, subsystemVendorId uint16, subsystemDeviceId uint16) string {
+	if db == nil {
+		return ""
+	}
+	return db.vendors[vendorId].devices[deviceId].subsystems[uint32(subsystemVendorId)<<16|uint32(subsystemDeviceId)]
+}
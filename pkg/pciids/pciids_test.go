@@ -0,0 +1,77 @@
+package pciids
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPCIIDs = `#
+#	List of PCI ID's
+#
+0e11  Compaq Computer Corporation
+	0001  PCI to EISA Bridge
+8086  Intel Corporation
+	1557  82599 10 Gigabit Network Connection
+		1dcf 0317  10GbE SFP+ Dual Port Adapter
+	1521  I350 Gigabit Network Connection
+		1028 0602  Gigabit 2P I350-t LOM
+		8086 00a1  Ethernet Server Adapter I350-T4
+
+# List of known device classes, subclasses and programming interfaces
+C 00  Unclassified device
+	00  Non-VGA unclassified device
+`
+
+func TestParse(t *testing.T) {
+	db, err := Parse(strings.NewReader(testPCIIDs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := db.VendorName(0x8086); name != "Intel Corporation" {
+		t.Errorf("expected Intel Corporation, got %q", name)
+	}
+	if name := db.DeviceName(0x8086, 0x1557); name != "82599 10 Gigabit Network Connection" {
+		t.Errorf("expected 82599 10 Gigabit Network Connection, got %q", name)
+	}
+	if name := db.SubsystemName(0x8086, 0x1521, 0x8086, 0x00a1); name != "Ethernet Server Adapter I350-T4" {
+		t.Errorf("expected Ethernet Server Adapter I350-T4, got %q", name)
+	}
+	if name := db.DeviceName(0x8086, 0xffff); name != "" {
+		t.Errorf("expected an unknown device, got %q", name)
+	}
+	// Classes are not vendors
+	if name := db.VendorName(0x0000); name != "" {
+		t.Errorf("expected an unknown vendor, got %q", name)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	// Devices and subsystems without a vendor, or with one that can't be parsed, are skipped
+	db, err := Parse(strings.NewReader("\t1557  82599 10 Gigabit Network Connection\n" +
+		"\t\t1dcf 0317  10GbE SFP+ Dual Port Adapter\n" +
+		"zzzz  Not a vendor\n" +
+		"\t1521  I350 Gigabit Network Connection\n" +
+		"8086  Intel Corporation\n" +
+		"\tzzzz  Not a device\n" +
+		"\t\t8086 00a1  Ethernet Server Adapter I350-T4\n" +
+		"\t1557  82599 10 Gigabit Network Connection\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := db.DeviceName(0x8086, 0x1557); name != "82599 10 Gigabit Network Connection" {
+		t.Errorf("expected 82599 10 Gigabit Network Connection, got %q", name)
+	}
+	if name := db.DeviceName(0x8086, 0x1521); name != "" {
+		t.Errorf("expected the device of the malformed vendor to be skipped, got %q", name)
+	}
+	if name := db.SubsystemName(0x8086, 0x1557, 0x8086, 0x00a1); name != "" {
+		t.Errorf("expected the subsystem of the malformed device to be skipped, got %q", name)
+	}
+}
+
+func TestNilDB(t *testing.T) {
+	var db *DB
+	if db.VendorName(0x8086) != "" || db.DeviceName(0x8086, 0x1557) != "" {
+		t.Fatal("expected a nil DB to know no names")
+	}
+}
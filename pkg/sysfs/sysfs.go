@@ -0,0 +1,597 @@
+// The sysfs module reads PCI device attributes directly from the kernel's
+// sysfs tree, so that discovery doesn't need to exec lspci for every device.
+// All paths are resolved relative to a configurable root, which lets tests
+// run against a fake sysfs tree.
+
+package sysfs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/u-root/u-root/pkg/pci"
+)
+
+const (
+	DefaultRoot       = "/sys"
+	DefaultModulesDir = "/lib/modules"
+)
+
+var (
+	ErrNoDriver     = errors.New("driver not found")
+	ErrNoIOMMUGroup = errors.New("iommu group not found")
+)
+
+// pciAddressPattern matches a PCI address like 0000:00:1c.0. Domains can be longer than
+// 4 digits, e.g. behind an Intel VMD controller.
+var pciAddressPattern = regexp.MustCompile(`^[0-9a-f]{4,}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+type SysFS struct {
+	root       string
+	modulesDir string
+
+	aliasesOnce sync.Once
+	aliases     []moduleAlias
+	aliasesErr  error
+}
+
+type moduleAlias struct {
+	pattern string
+	module  string
+}
+
+// New returns a SysFS rooted at root. The modules.alias database used to
+// resolve a device's modalias is read from modulesDir; if modulesDir is empty,
+// it defaults to the directory of the running kernel under /lib/modules.
+func New(root string, modulesDir string) *SysFS {
+	if root == "" {
+		root = DefaultRoot
+	}
+	return &SysFS{
+		root:       root,
+		modulesDir: modulesDir,
+	}
+}
+
+func (s *SysFS) Root() string {
+	return s.root
+}
+
+func (s *SysFS) DevicesDir() string {
+	return filepath.Join(s.root, "bus", "pci", "devices")
+}
+
+func (s *SysFS) DriversDir() string {
+	return filepath.Join(s.root, "bus", "pci", "drivers")
+}
+
+func (s *SysFS) DevicePath(addr string) string {
+	return filepath.Join(s.DevicesDir(), addr)
+}
+
+// Devices lists every PCI device on the bus, sorted by address
+func (s *SysFS) Devices() ([]*pci.PCI, error) {
+	dirs, err := filepath.Glob(filepath.Join(s.DevicesDir(), "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirs)
+	devices := make([]*pci.PCI, 0, len(dirs))
+	for _, dir := range dirs {
+		dev, err := s.Device(filepath.Base(dir))
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+// Device reads a single PCI device
+func (s *SysFS) Device(addr string) (*pci.PCI, error) {
+	return pci.OnePCI(s.DevicePath(addr))
+}
+
+// Driver returns the name of the kernel driver currently bound to the device
+func (s *SysFS) Driver(addr string) (string, error) {
+	link, err := os.Readlink(filepath.Join(s.DevicePath(addr), "driver"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNoDriver
+		}
+		return "", err
+	}
+	return filepath.Base(link), nil
+}
+
+func (s *SysFS) Modalias(addr string) (string, error) {
+	return s.readString(addr, "modalias")
+}
+
+// KernelModules returns the kernel modules that are able to drive the device,
+// by matching its modalias against the modules.alias database
+func (s *SysFS) KernelModules(addr string) ([]string, error) {
+	modalias, err := s.Modalias(addr)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := s.moduleAliases()
+	if err != nil {
+		return nil, err
+	}
+	var modules []string
+	seen := map[string]bool{}
+	for _, a := range aliases {
+		if seen[a.module] {
+			continue
+		}
+		if matched, _ := path.Match(a.pattern, modalias); matched {
+			seen[a.module] = true
+			modules = append(modules, a.module)
+		}
+	}
+	if len(modules) == 0 {
+		return nil, errors.New("modules not found")
+	}
+	return modules, nil
+}
+
+// Class returns the 24-bit class code, made up of class, subclass and prog-if
+func (s *SysFS) Class(addr string) (uint32, error) {
+	n, err := s.readUint(addr, "class", 24)
+	return uint32(n), err
+}
+
+func (s *SysFS) SubsystemVendor(addr string) (uint16, error) {
+	n, err := s.readUint(addr, "subsystem_vendor", 16)
+	return uint16(n), err
+}
+
+func (s *SysFS) SubsystemDevice(addr string) (uint16, error) {
+	n, err := s.readUint(addr, "subsystem_device", 16)
+	return uint16(n), err
+}
+
+func (s *SysFS) Revision(addr string) (uint8, error) {
+	n, err := s.readUint(addr, "revision", 8)
+	return uint8(n), err
+}
+
+// IOMMUGroup returns the IOMMU group of the device. Devices have no group if the IOMMU is disabled.
+func (s *SysFS) IOMMUGroup(addr string) (string, error) {
+	link, err := os.Readlink(filepath.Join(s.DevicePath(addr), "iommu_group"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNoIOMMUGroup
+		}
+		return "", err
+	}
+	return filepath.Base(link), nil
+}
+
+// IOMMUGroupDevices returns the addresses of all the devices in the IOMMU group, sorted
+func (s *SysFS) IOMMUGroupDevices(group string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, "kernel", "iommu_groups", group, "devices"))
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		addrs = append(addrs, entry.Name())
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// UpstreamBridges returns the addresses of the bridges between the device and the root complex,
+// starting with the root port, or nothing for devices that are integrated in the root complex.
+// The device's path in sysfs follows the bus hierarchy, e.g.
+// /sys/devices/pci0000:00/0000:00:1c.0/0000:02:00.0/0000:03:00.0
+func (s *SysFS) UpstreamBridges(addr string) ([]string, error) {
+	path, err := filepath.EvalSymlinks(s.DevicePath(addr))
+	if err != nil {
+		return nil, err
+	}
+	var bridges []string
+	for _, component := range strings.Split(filepath.ToSlash(path), "/") {
+		if component != addr && pciAddressPattern.MatchString(component) {
+			bridges = append(bridges, component)
+		}
+	}
+	return bridges, nil
+}
+
+// NUMANode returns the NUMA node the device is attached to, or -1 if the platform doesn't report one
+func (s *SysFS) NUMANode(addr string) (int, error) {
+	v, err := s.readString(addr, "numa_node")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return -1, nil
+		}
+		return -1, err
+	}
+	numaNode, err := strconv.Atoi(v)
+	if err != nil {
+		return -1, err
+	}
+	return numaNode, nil
+}
+
+// LocalCPUList returns the CPUs local to the device, in cpulist format, e.g. "0-15,32-47"
+func (s *SysFS) LocalCPUList(addr string) (string, error) {
+	return s.readString(addr, "local_cpulist")
+}
+
+// CurrentLinkSpeed returns the negotiated PCIe link speed, e.g. "8.0 GT/s PCIe".
+// Only PCI Express devices have a link speed.
+func (s *SysFS) CurrentLinkSpeed(addr string) (string, error) {
+	return s.readString(addr, "current_link_speed")
+}
+
+func (s *SysFS) MaxLinkSpeed(addr string) (string, error) {
+	return s.readString(addr, "max_link_speed")
+}
+
+// CurrentLinkWidth returns the negotiated number of PCIe lanes
+func (s *SysFS) CurrentLinkWidth(addr string) (int, error) {
+	return s.readInt(addr, "current_link_width")
+}
+
+func (s *SysFS) MaxLinkWidth(addr string) (int, error) {
+	return s.readInt(addr, "max_link_width")
+}
+
+// Unbind unbinds the device from the driver
+func (s *SysFS) Unbind(addr string, driver string) error {
+	return writeFile(filepath.Join(s.DriversDir(), driver, "unbind"), addr)
+}
+
+// Bind binds the device to the driver, which must be loaded
+func (s *SysFS) Bind(addr string, driver string) error {
+	return writeFile(filepath.Join(s.DriversDir(), driver, "bind"), addr)
+}
+
+// DriverLoaded is true if the driver is registered on the PCI bus
+func (s *SysFS) DriverLoaded(driver string) bool {
+	_, err := os.Stat(filepath.Join(s.DriversDir(), driver))
+	return err == nil
+}
+
+// ModuleLoaded is true if the kernel module is loaded, or built into the kernel
+func (s *SysFS) ModuleLoaded(module string) bool {
+	_, err := os.Stat(filepath.Join(s.root, "module", module))
+	return err == nil
+}
+
+// SetDriverOverride makes the driver the only one that may bind to the device, or
+// clears the override if driver is empty
+func (s *SysFS) SetDriverOverride(addr string, driver string) error {
+	if driver == "" {
+		// An empty string is refused, a newline clears the override
+		driver = "\n"
+	}
+	return writeFile(filepath.Join(s.DevicePath(addr), "driver_override"), driver)
+}
+
+// DriversProbe binds the device to the first driver that accepts it, which is the driver
+// override if the device has one
+func (s *SysFS) DriversProbe(addr string) error {
+	return writeFile(filepath.Join(s.root, "bus", "pci", "drivers_probe"), addr)
+}
+
+// RemoveDriverID removes vendor and device IDs that were added to the driver through new_id.
+// The kernel refuses IDs that weren't added with ENODEV.
+func (s *SysFS) RemoveDriverID(driver string, vendorId uint16, deviceId uint16) error {
+	return writeFile(filepath.Join(s.DriversDir(), driver, "remove_id"), fmt.Sprintf("%04x %04x", vendorId, deviceId))
+}
+
+// ModuleParameter returns the value of a parameter of a loaded kernel module
+func (s *SysFS) ModuleParameter(module string, name string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(s.root, "module", module, "parameters", name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// ResetMethods returns the methods the kernel can reset the device with, in the order it tries them,
+// e.g. [flr bus]. It's empty if the device can't be reset, or the kernel is older than 5.15.
+func (s *SysFS) ResetMethods(addr string) ([]string, error) {
+	v, err := s.readString(addr, "reset_method")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Fields(v), nil
+}
+
+// Reset resets the device with the method, e.g. "flr" or "bus", or with the first of its reset methods
+// that works if method is empty. Nothing may be using the device while it's reset.
+func (s *SysFS) Reset(addr string, method string) (err error) {
+	if method != "" {
+		methodPath := filepath.Join(s.DevicePath(addr), "reset_method")
+		if err := writeFile(methodPath, method); err != nil {
+			return fmt.Errorf("selecting reset method %s of %s: %w", method, addr, err)
+		}
+		defer func() {
+			if restoreErr := writeFile(methodPath, "default"); restoreErr != nil && err == nil {
+				err = fmt.Errorf("restoring the reset methods of %s: %w", addr, restoreErr)
+			}
+		}()
+	}
+	if err := writeFile(filepath.Join(s.DevicePath(addr), "reset"), "1"); err != nil {
+		return fmt.Errorf("resetting %s: %w", addr, err)
+	}
+	return nil
+}
+
+// Kinds of AER errors, which the kernel counts in the aer_dev_<kind> files
+const (
+	AERCorrectable = "correctable"
+	AERNonFatal    = "nonfatal"
+	AERFatal       = "fatal"
+)
+
+// AERTotal returns the number of AER errors of the kind that the device reported since it was
+// enumerated. Only PCI Express devices with Advanced Error Reporting have the counters, for the
+// others the returned error satisfies os.IsNotExist.
+func (s *SysFS) AERTotal(addr string, kind string) (int64, error) {
+	file := "aer_dev_" + kind
+	v, err := s.readString(addr, file)
+	if err != nil {
+		return 0, err
+	}
+	// The last line is the total, e.g. "TOTAL_ERR_FATAL 0", after one line per error type
+	for _, line := range strings.Split(v, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[0], "TOTAL_ERR_") {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing %s of %s: %w", file, addr, err)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("parsing %s of %s: no total", file, addr)
+}
+
+// Flags of a Resource, from the kernel's include/linux/ioport.h
+const (
+	ResourceIO       = 0x00000100
+	ResourceMem      = 0x00000200
+	ResourcePrefetch = 0x00002000
+	ResourceMem64    = 0x00100000
+	resourceROMIndex = 6
+)
+
+// Resource is a region of I/O or memory space decoded by a device
+type Resource struct {
+	// Index is the BAR number, or 6 for the expansion ROM
+	Index int
+	Start uint64
+	End   uint64
+	Flags uint64
+}
+
+func (r Resource) Size() uint64 {
+	if r.End == 0 && r.Start == 0 {
+		return 0
+	}
+	return r.End - r.Start + 1
+}
+
+func (r Resource) IsIO() bool {
+	return r.Flags&ResourceIO != 0
+}
+
+func (r Resource) Is64Bit() bool {
+	return r.Flags&ResourceMem64 != 0
+}
+
+func (r Resource) IsPrefetchable() bool {
+	return r.Flags&ResourcePrefetch != 0
+}
+
+// Resources returns the regions of the device's six BARs that are in use, and its expansion ROM, if any.
+// They are read from the resource file, whose lines look like
+// "0x00000000f0000000 0x00000000f0ffffff 0x0000000000040200", one per resource.
+func (s *SysFS) Resources(addr string) (bars []Resource, rom *Resource, err error) {
+	content, err := s.readString(addr, "resource")
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, line := range strings.Split(content, "\n") {
+		if i > resourceROMIndex {
+			// The rest are the SR-IOV BARs and bridge windows
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, nil, fmt.Errorf("parsing resource of %s: unexpected line %q", addr, line)
+		}
+		var values [3]uint64
+		for j, field := range fields {
+			values[j], err = strconv.ParseUint(strings.TrimPrefix(field, "0x"), 16, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("parsing resource of %s: %w", addr, err)
+			}
+		}
+		r := Resource{Index: i, Start: values[0], End: values[1], Flags: values[2]}
+		if r.Size() == 0 {
+			continue
+		}
+		if i == resourceROMIndex {
+			rom = &r
+			continue
+		}
+		bars = append(bars, r)
+	}
+	return bars, rom, nil
+}
+
+// SRIOVTotalVFs returns the number of VFs a PF supports, or 0 if the device is not an SR-IOV PF
+func (s *SysFS) SRIOVTotalVFs(addr string) (int, error) {
+	return s.readInt(addr, "sriov_totalvfs")
+}
+
+// SRIOVNumVFs returns the number of VFs currently enabled on a PF
+func (s *SysFS) SRIOVNumVFs(addr string) (int, error) {
+	return s.readInt(addr, "sriov_numvfs")
+}
+
+// PhysFn returns the address of the PF of a VF, or "" if the device is not a VF
+func (s *SysFS) PhysFn(addr string) (string, error) {
+	link, err := os.Readlink(filepath.Join(s.DevicePath(addr), "physfn"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return filepath.Base(link), nil
+}
+
+// VirtFns returns the addresses of the VFs of a PF, in VF index order
+func (s *SysFS) VirtFns(addr string) ([]string, error) {
+	links, err := filepath.Glob(filepath.Join(s.DevicePath(addr), "virtfn*"))
+	if err != nil {
+		return nil, err
+	}
+	// virtfn10 sorts before virtfn2, so sort by index
+	sort.Slice(links, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(links[i]), "virtfn"))
+		b, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(links[j]), "virtfn"))
+		return a < b
+	})
+	var vfs []string
+	for _, link := range links {
+		target, err := os.Readlink(link)
+		if err != nil {
+			return nil, err
+		}
+		vfs = append(vfs, filepath.Base(target))
+	}
+	return vfs, nil
+}
+
+// SetSRIOVNumVFs changes the number of VFs enabled on a PF. The kernel doesn't allow
+// changing a non-zero number of VFs directly, so any existing VFs are removed first.
+func (s *SysFS) SetSRIOVNumVFs(addr string, numVFs int) error {
+	current, err := s.SRIOVNumVFs(addr)
+	if err != nil {
+		return err
+	}
+	if current == numVFs {
+		return nil
+	}
+	path := filepath.Join(s.DevicePath(addr), "sriov_numvfs")
+	if current != 0 {
+		if err := writeFile(path, "0"); err != nil {
+			return err
+		}
+	}
+	if numVFs == 0 {
+		return nil
+	}
+	return writeFile(path, strconv.Itoa(numVFs))
+}
+
+func (s *SysFS) readInt(addr string, file string) (int, error) {
+	v, err := s.readString(addr, file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+func (s *SysFS) readString(addr string, file string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(s.DevicePath(addr), file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (s *SysFS) readUint(addr string, file string, bits int) (uint64, error) {
+	v, err := s.readString(addr, file)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(v, "0x"), 16, bits)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s of %s: %w", file, addr, err)
+	}
+	return n, nil
+}
+
+func (s *SysFS) moduleAliases() ([]moduleAlias, error) {
+	s.aliasesOnce.Do(func() {
+		dir := s.modulesDir
+		if dir == "" {
+			release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+			if err != nil {
+				s.aliasesErr = err
+				return
+			}
+			dir = filepath.Join(DefaultModulesDir, strings.TrimSpace(string(release)))
+		}
+		for _, name := range []string{"modules.alias", "modules.builtin.alias"} {
+			aliases, err := readModuleAliases(filepath.Join(dir, name))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				s.aliasesErr = err
+				return
+			}
+			s.aliases = append(s.aliases, aliases...)
+		}
+	})
+	return s.aliases, s.aliasesErr
+}
+
+// readModuleAliases parses the pci entries of a modules.alias file, whose
+// lines look like "alias pci:v00008086d00001557sv*sd*bc*sc*i* ixgbe"
+func readModuleAliases(filename string) ([]moduleAlias, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var aliases []moduleAlias
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != "alias" || !strings.HasPrefix(fields[1], "pci:") {
+			continue
+		}
+		aliases = append(aliases, moduleAlias{pattern: fields[1], module: fields[2]})
+	}
+	return aliases, scanner.Err()
+}
+
+func writeFile(path string, value string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0200)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(value)
+	return err
+}
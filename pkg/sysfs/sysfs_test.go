@@ -0,0 +1,360 @@
+package sysfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/harvester/pcidevices/pkg/sysfs/sysfstest"
+)
+
+var intel82599 = sysfstest.Device{
+	Addr:            "0000:22:00.0",
+	Vendor:          0x8086,
+	Device:          0x1557,
+	Class:           0x020000,
+	SubsystemVendor: 0x1dcf,
+	SubsystemDevice: 0x0317,
+	Revision:        0x01,
+	Driver:          "vfio-pci",
+}
+
+var intel82801IB = sysfstest.Device{
+	Addr:            "0000:00:1f.2",
+	Vendor:          0x8086,
+	Device:          0x2921,
+	Class:           0x01018f,
+	SubsystemVendor: 0x1028,
+	SubsystemDevice: 0x0235,
+	Revision:        0x02,
+	Driver:          "ata_piix",
+}
+
+func newTestSysFS(t *testing.T) *SysFS {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(intel82599)
+	tree.AddDevice(intel82801IB)
+	modulesDir := tree.WriteModulesAlias(
+		"alias pci:v00008086d00001557sv*sd*bc*sc*i* ixgbe",
+		"alias pci:v*d*sv*sd*bc01sc01i* ata_generic",
+		"alias pci:v*d*sv*sd*bc01sc01i* pata_acpi",
+		"alias pci:v00008086d00002921sv*sd*bc*sc*i* ata_piix",
+		"alias pci:v00008086d00002921sv*sd*bc*sc*i* ata_piix",
+		"alias usb:v*p*d*dc*dsc*dp*ic09isc*ip*in* usbcore",
+	)
+	return New(tree.Root, modulesDir)
+}
+
+func TestDevices(t *testing.T) {
+	fs := newTestSysFS(t)
+	devices, err := fs.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+	// Devices are sorted by address
+	if devices[0].Addr != intel82801IB.Addr || devices[1].Addr != intel82599.Addr {
+		t.Fatalf("unexpected device order: %s, %s", devices[0].Addr, devices[1].Addr)
+	}
+	if devices[1].Vendor != 0x8086 || devices[1].Device != 0x1557 {
+		t.Fatalf("unexpected ids %x:%x", devices[1].Vendor, devices[1].Device)
+	}
+}
+
+func TestDriver(t *testing.T) {
+	fs := newTestSysFS(t)
+	actual, err := fs.Driver(intel82599.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != "vfio-pci" {
+		t.Fatalf("expected vfio-pci, got %s", actual)
+	}
+}
+
+func TestDriverNotBound(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{Addr: "0000:01:00.0", Vendor: 0x10de, Device: 0x1eb8})
+	_, err := New(tree.Root, "").Driver("0000:01:00.0")
+	if err != ErrNoDriver {
+		t.Fatalf("expected ErrNoDriver, got %v", err)
+	}
+}
+
+func TestKernelModules(t *testing.T) {
+	fs := newTestSysFS(t)
+	actual, err := fs.KernelModules(intel82801IB.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ata_generic", "pata_acpi", "ata_piix"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	actual, err = fs.KernelModules(intel82599.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, []string{"ixgbe"}) {
+		t.Fatalf("expected [ixgbe], got %v", actual)
+	}
+}
+
+func TestIdentification(t *testing.T) {
+	fs := newTestSysFS(t)
+	class, err := fs.Class(intel82599.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subVendor, err := fs.SubsystemVendor(intel82599.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subDevice, err := fs.SubsystemDevice(intel82599.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revision, err := fs.Revision(intel82599.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class != 0x020000 || subVendor != 0x1dcf || subDevice != 0x0317 || revision != 0x01 {
+		t.Fatalf("unexpected identification %06x %04x:%04x rev %02x", class, subVendor, subDevice, revision)
+	}
+}
+
+func TestIOMMUGroup(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	gpu := sysfstest.Device{Addr: "0000:01:00.0", Vendor: 0x10de, Device: 0x1eb8}
+	gpuAudio := sysfstest.Device{Addr: "0000:01:00.1", Vendor: 0x10de, Device: 0x10f8}
+	tree.AddDevice(gpu)
+	tree.AddDevice(gpuAudio)
+	tree.AddDevice(intel82599)
+	tree.SetIOMMUGroup(gpu.Addr, "13")
+	tree.SetIOMMUGroup(gpuAudio.Addr, "13")
+	fs := New(tree.Root, "")
+
+	group, err := fs.IOMMUGroup(gpu.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group != "13" {
+		t.Fatalf("expected group 13, got %s", group)
+	}
+	devices, err := fs.IOMMUGroupDevices(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(devices, []string{gpu.Addr, gpuAudio.Addr}) {
+		t.Fatalf("unexpected group devices %v", devices)
+	}
+	if _, err := fs.IOMMUGroup(intel82599.Addr); err != ErrNoIOMMUGroup {
+		t.Fatalf("expected ErrNoIOMMUGroup, got %v", err)
+	}
+}
+
+func TestSRIOV(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	pf := sysfstest.Device{Addr: "0000:3b:00.0", Vendor: 0x15b3, Device: 0x1017}
+	tree.AddDevice(pf)
+	var vfs []sysfstest.Device
+	for i := 0; i < 11; i++ {
+		vfs = append(vfs, sysfstest.Device{Addr: fmt.Sprintf("0000:3b:%02x.%d", 1+i/8, i%8), Vendor: 0x15b3, Device: 0x1018})
+	}
+	tree.AddVFs(pf, 16, vfs...)
+	fs := New(tree.Root, "")
+
+	totalVFs, err := fs.SRIOVTotalVFs(pf.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numVFs, err := fs.SRIOVNumVFs(pf.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totalVFs != 16 || numVFs != 11 {
+		t.Fatalf("expected 16 total and 11 enabled VFs, got %d and %d", totalVFs, numVFs)
+	}
+	virtFns, err := fs.VirtFns(pf.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(virtFns) != 11 || virtFns[2] != vfs[2].Addr || virtFns[10] != vfs[10].Addr {
+		t.Fatalf("unexpected VFs %v", virtFns)
+	}
+	physFn, err := fs.PhysFn(vfs[10].Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if physFn != pf.Addr {
+		t.Fatalf("expected PF %s, got %s", pf.Addr, physFn)
+	}
+	// A PF is not a VF, and a VF can't have VFs
+	if physFn, _ := fs.PhysFn(pf.Addr); physFn != "" {
+		t.Fatalf("expected no PF for a PF, got %s", physFn)
+	}
+	if totalVFs, _ := fs.SRIOVTotalVFs(vfs[0].Addr); totalVFs != 0 {
+		t.Fatalf("expected no VFs for a VF, got %d", totalVFs)
+	}
+}
+
+func TestNUMA(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{
+		Addr:  "0000:af:00.0",
+		Extra: map[string]string{"numa_node": "1", "local_cpulist": "16-31,48-63"},
+	})
+	tree.AddDevice(sysfstest.Device{Addr: "0000:00:1f.6", Extra: map[string]string{"numa_node": "-1"}})
+	tree.AddDevice(sysfstest.Device{Addr: "0000:00:1f.7", Extra: map[string]string{"numa_node": "garbage"}})
+	fs := New(tree.Root, "")
+
+	numaNode, err := fs.NUMANode("0000:af:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpuList, err := fs.LocalCPUList("0000:af:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numaNode != 1 || cpuList != "16-31,48-63" {
+		t.Fatalf("expected NUMA node 1 with CPUs 16-31,48-63, got %d with %s", numaNode, cpuList)
+	}
+	if numaNode, _ := fs.NUMANode("0000:00:1f.6"); numaNode != -1 {
+		t.Fatalf("expected no NUMA node, got %d", numaNode)
+	}
+	if numaNode, err := fs.NUMANode("0000:00:1f.7"); err == nil || numaNode != -1 {
+		t.Fatalf("expected no NUMA node with an error, got %d, %v", numaNode, err)
+	}
+}
+
+func TestResources(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{
+		Addr: "0000:01:00.0",
+		Extra: map[string]string{"resource": strings.Join([]string{
+			"0x00000000fb000000 0x00000000fbffffff 0x0000000000040200",
+			"0x0000006000000000 0x00000067ffffffff 0x000000000014220c",
+			"0x0000000000000000 0x0000000000000000 0x0000000000000000",
+			"0x0000000000000000 0x0000000000000000 0x0000000000000000",
+			"0x0000000000000000 0x0000000000000000 0x0000000000000000",
+			"0x000000000000e000 0x000000000000e07f 0x0000000000040101",
+			"0x00000000fc000000 0x00000000fc07ffff 0x0000000000046200",
+			"0x0000000000000000 0x0000000000000000 0x0000000000000000",
+		}, "\n")},
+	})
+	fs := New(tree.Root, "")
+
+	bars, rom, err := fs.Resources("0000:01:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bars) != 3 {
+		t.Fatalf("expected 3 BARs, got %+v", bars)
+	}
+	if bars[0].Index != 0 || bars[0].Size() != 16<<20 || bars[0].Is64Bit() || bars[0].IsPrefetchable() {
+		t.Errorf("unexpected BAR 0 %+v", bars[0])
+	}
+	if bars[1].Index != 1 || bars[1].Size() != 32<<30 || !bars[1].Is64Bit() || !bars[1].IsPrefetchable() {
+		t.Errorf("unexpected BAR 1 %+v", bars[1])
+	}
+	if bars[2].Index != 5 || bars[2].Size() != 128 || !bars[2].IsIO() {
+		t.Errorf("unexpected BAR 5 %+v", bars[2])
+	}
+	if rom == nil || rom.Size() != 512<<10 {
+		t.Errorf("unexpected ROM %+v", rom)
+	}
+}
+
+func TestUpstreamBridges(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	rootPort := sysfstest.Device{Addr: "0000:00:01.0", Class: 0x060400}
+	switchUp := sysfstest.Device{Addr: "0000:01:00.0", Class: 0x060400, Parent: rootPort.Addr}
+	switchDown := sysfstest.Device{Addr: "0000:02:08.0", Class: 0x060400, Parent: switchUp.Addr}
+	gpu := sysfstest.Device{Addr: "0000:03:00.0", Class: 0x030000, Parent: switchDown.Addr}
+	for _, d := range []sysfstest.Device{rootPort, switchUp, switchDown, gpu} {
+		tree.AddDevice(d)
+	}
+	fs := New(tree.Root, "")
+
+	bridges, err := fs.UpstreamBridges(gpu.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{rootPort.Addr, switchUp.Addr, switchDown.Addr}
+	if !reflect.DeepEqual(bridges, expected) {
+		t.Fatalf("expected %v, got %v", expected, bridges)
+	}
+	if bridges, _ := fs.UpstreamBridges(rootPort.Addr); len(bridges) != 0 {
+		t.Fatalf("expected no bridges above the root port, got %v", bridges)
+	}
+}
+
+func TestAERTotal(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{
+		Addr: "0000:01:00.0",
+		Extra: map[string]string{
+			"aer_dev_correctable": "RxErr 2\nBadTLP 1\nBadDLLP 0\nTOTAL_ERR_COR 3",
+			"aer_dev_nonfatal":    "Undefined 0\nDLP 0\nTOTAL_ERR_NONFATAL 0",
+			"aer_dev_fatal":       "Undefined 0\nDLP 1\nTOTAL_ERR_FATAL 1",
+		},
+	})
+	tree.AddDevice(sysfstest.Device{Addr: "0000:00:1f.0"})
+	fs := New(tree.Root, "")
+
+	expected := map[string]int64{AERCorrectable: 3, AERNonFatal: 0, AERFatal: 1}
+	for kind, count := range expected {
+		actual, err := fs.AERTotal("0000:01:00.0", kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != count {
+			t.Errorf("expected %d %s errors, got %d", count, kind, actual)
+		}
+	}
+	if _, err := fs.AERTotal("0000:00:1f.0", AERFatal); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error for a device without AER, got %v", err)
+	}
+}
+
+func TestReset(t *testing.T) {
+	tree := sysfstest.NewTree(t)
+	tree.AddDevice(sysfstest.Device{
+		Addr:  "0000:01:00.0",
+		Extra: map[string]string{"reset_method": "flr bus", "reset": ""},
+	})
+	tree.AddDevice(sysfstest.Device{Addr: "0000:00:1f.0"})
+	fs := New(tree.Root, "")
+
+	methods, err := fs.ResetMethods("0000:01:00.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(methods, []string{"flr", "bus"}) {
+		t.Fatalf("expected flr and bus, got %v", methods)
+	}
+	if methods, _ := fs.ResetMethods("0000:00:1f.0"); len(methods) != 0 {
+		t.Fatalf("expected no reset methods, got %v", methods)
+	}
+
+	if err := fs.Reset("0000:01:00.0", "bus"); err != nil {
+		t.Fatal(err)
+	}
+	for file, expected := range map[string]string{"reset": "1", "reset_method": "default"} {
+		actual, err := os.ReadFile(filepath.Join(fs.DevicePath("0000:01:00.0"), file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != expected {
+			t.Errorf("expected %s to be %q, got %q", file, expected, actual)
+		}
+	}
+	if err := fs.Reset("0000:00:1f.0", ""); err == nil {
+		t.Fatal("expected an error resetting a device without reset")
+	}
+}
@@ -0,0 +1,168 @@
+// The sysfstest module builds fake sysfs trees for tests
+
+package sysfstest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Device describes a fake PCI device. Empty attributes are not written.
+type Device struct {
+	Addr            string
+	Vendor          uint16
+	Device          uint16
+	Class           uint32
+	SubsystemVendor uint16
+	SubsystemDevice uint16
+	Revision        uint8
+	Driver          string
+	// Parent is the address of the bridge the device is behind, which must be added first.
+	// Devices without a parent are on the root bus.
+	Parent string
+	// Extra holds any other attribute files, keyed by file name
+	Extra map[string]string
+}
+
+func (d Device) Modalias() string {
+	return fmt.Sprintf(
+		"pci:v%08Xd%08Xsv%08Xsd%08Xbc%02Xsc%02Xi%02X",
+		d.Vendor, d.Device, d.SubsystemVendor, d.SubsystemDevice,
+		(d.Class>>16)&0xff, (d.Class>>8)&0xff, d.Class&0xff,
+	)
+}
+
+// Tree is a fake sysfs tree rooted in a temporary directory
+type Tree struct {
+	t    *testing.T
+	Root string
+}
+
+func NewTree(t *testing.T) *Tree {
+	t.Helper()
+	tree := &Tree{t: t, Root: t.TempDir()}
+	tree.mkdir(tree.DevicesDir())
+	tree.mkdir(tree.DriversDir())
+	return tree
+}
+
+func (tree *Tree) DevicesDir() string {
+	return filepath.Join(tree.Root, "bus", "pci", "devices")
+}
+
+func (tree *Tree) DriversDir() string {
+	return filepath.Join(tree.Root, "bus", "pci", "drivers")
+}
+
+// AddDevice writes the device's attribute files, and links it to its driver
+func (tree *Tree) AddDevice(d Device) {
+	tree.t.Helper()
+	parentDir := filepath.Join(tree.Root, "devices", "pci0000:00")
+	if d.Parent != "" {
+		var err error
+		if parentDir, err = filepath.EvalSymlinks(filepath.Join(tree.DevicesDir(), d.Parent)); err != nil {
+			tree.t.Fatal(err)
+		}
+	}
+	dir := filepath.Join(parentDir, d.Addr)
+	tree.mkdir(dir)
+	files := map[string]string{
+		"vendor":           fmt.Sprintf("0x%04x", d.Vendor),
+		"device":           fmt.Sprintf("0x%04x", d.Device),
+		"class":            fmt.Sprintf("0x%06x", d.Class),
+		"subsystem_vendor": fmt.Sprintf("0x%04x", d.SubsystemVendor),
+		"subsystem_device": fmt.Sprintf("0x%04x", d.SubsystemDevice),
+		"revision":         fmt.Sprintf("0x%02x", d.Revision),
+		"modalias":         d.Modalias(),
+		"irq":              "0",
+		"resource":         "0x0000000000000000 0x0000000000000000 0x0000000000000000",
+	}
+	for name, value := range d.Extra {
+		files[name] = value
+	}
+	for name, value := range files {
+		tree.WriteFile(filepath.Join(dir, name), value)
+	}
+	tree.symlink(dir, filepath.Join(tree.DevicesDir(), d.Addr))
+	if d.Driver != "" {
+		tree.Bind(d.Addr, d.Driver)
+	}
+}
+
+// RemoveDevice simulates a hot-unplug of the device
+func (tree *Tree) RemoveDevice(addr string) {
+	tree.t.Helper()
+	if err := os.Remove(filepath.Join(tree.DevicesDir(), addr)); err != nil {
+		tree.t.Fatal(err)
+	}
+}
+
+// Bind links the device to the driver, replacing any existing binding
+func (tree *Tree) Bind(addr string, driver string) {
+	tree.t.Helper()
+	driverDir := filepath.Join(tree.DriversDir(), driver)
+	tree.mkdir(driverDir)
+	link := filepath.Join(tree.DevicesDir(), addr, "driver")
+	_ = os.Remove(link)
+	tree.symlink(driverDir, link)
+}
+
+// SetIOMMUGroup puts the device in the IOMMU group
+func (tree *Tree) SetIOMMUGroup(addr string, group string) {
+	tree.t.Helper()
+	groupDir := filepath.Join(tree.Root, "kernel", "iommu_groups", group)
+	tree.mkdir(filepath.Join(groupDir, "devices"))
+	tree.symlink(groupDir, filepath.Join(tree.DevicesDir(), addr, "iommu_group"))
+	tree.symlink(filepath.Join(tree.DevicesDir(), addr), filepath.Join(groupDir, "devices", addr))
+}
+
+// AddVFs adds the VFs of an SR-IOV PF, and links them to it
+func (tree *Tree) AddVFs(pf Device, totalVFs int, vfs ...Device) {
+	tree.t.Helper()
+	pfDir := filepath.Join(tree.DevicesDir(), pf.Addr)
+	tree.WriteFile(filepath.Join(pfDir, "sriov_totalvfs"), fmt.Sprint(totalVFs))
+	tree.WriteFile(filepath.Join(pfDir, "sriov_numvfs"), fmt.Sprint(len(vfs)))
+	for i, vf := range vfs {
+		tree.AddDevice(vf)
+		vfDir := filepath.Join(tree.DevicesDir(), vf.Addr)
+		tree.symlink(pfDir, filepath.Join(vfDir, "physfn"))
+		tree.symlink(vfDir, filepath.Join(pfDir, fmt.Sprintf("virtfn%d", i)))
+	}
+}
+
+// WriteModulesAlias writes a modules.alias database and returns its directory
+func (tree *Tree) WriteModulesAlias(lines ...string) string {
+	tree.t.Helper()
+	dir := filepath.Join(tree.Root, "lib", "modules", "test")
+	tree.mkdir(dir)
+	var content string
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	tree.WriteFile(filepath.Join(dir, "modules.alias"), content)
+	return dir
+}
+
+func (tree *Tree) WriteFile(name string, value string) {
+	tree.t.Helper()
+	tree.mkdir(filepath.Dir(name))
+	if err := os.WriteFile(name, []byte(value+"\n"), 0644); err != nil {
+		tree.t.Fatal(err)
+	}
+}
+
+func (tree *Tree) mkdir(dir string) {
+	tree.t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		tree.t.Fatal(err)
+	}
+}
+
+func (tree *Tree) symlink(oldname string, newname string) {
+	tree.t.Helper()
+	if err := os.Symlink(oldname, newname); err != nil {
+		tree.t.Fatal(err)
+	}
+}
@@ -0,0 +1,66 @@
+// The topology module prints the PCI bus tree of a node from its PCIDevices,
+// like `lspci -tv` does on the node itself.
+
+package topology
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+)
+
+// Print writes the tree of the PCIDevices of the node to out. Devices whose parent
+// bridge has no PCIDevice are printed at the top level.
+func Print(out io.Writer, pds []v1beta1.PCIDevice, nodeName string) error {
+	byAddress := map[string]*v1beta1.PCIDevice{}
+	for i := range pds {
+		if pds[i].Status.NodeName == nodeName {
+			byAddress[pds[i].Status.Address] = &pds[i]
+		}
+	}
+	if len(byAddress) == 0 {
+		return fmt.Errorf("no PCI devices found on node %s", nodeName)
+	}
+
+	var roots []string
+	children := map[string][]string{}
+	for addr, pd := range byAddress {
+		parent := pd.Status.ParentAddress
+		if _, found := byAddress[parent]; parent == "" || !found {
+			roots = append(roots, addr)
+			continue
+		}
+		children[parent] = append(children[parent], addr)
+	}
+	sort.Strings(roots)
+	for _, addrs := range children {
+		sort.Strings(addrs)
+	}
+
+	var printTree func(addr string, prefix string, childPrefix string) error
+	printTree = func(addr string, prefix string, childPrefix string) error {
+		if _, err := fmt.Fprintf(out, "%s%s %s\n", prefix, addr, byAddress[addr].Status.Description); err != nil {
+			return err
+		}
+		for i, child := range children[addr] {
+			if i == len(children[addr])-1 {
+				if err := printTree(child, childPrefix+"└── ", childPrefix+"    "); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := printTree(child, childPrefix+"├── ", childPrefix+"│   "); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, addr := range roots {
+		if err := printTree(addr, "", ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
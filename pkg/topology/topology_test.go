@@ -0,0 +1,52 @@
+package topology
+
+import (
+	"bytes"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+)
+
+func newPCIDevice(nodeName string, addr string, parent string, description string) v1beta1.PCIDevice {
+	return v1beta1.PCIDevice{
+		ObjectMeta: metav1.ObjectMeta{Name: nodeName + "-" + addr},
+		Status: v1beta1.PCIDeviceStatus{
+			Address:       addr,
+			NodeName:      nodeName,
+			ParentAddress: parent,
+			Description:   description,
+		},
+	}
+}
+
+func TestPrint(t *testing.T) {
+	pds := []v1beta1.PCIDevice{
+		newPCIDevice("node1", "0000:03:00.0", "0000:02:08.0", "VGA compatible controller: NVIDIA Corporation TU104GL [Tesla T4]"),
+		newPCIDevice("node1", "0000:00:1f.6", "", "Ethernet controller: Intel Corporation Ethernet Connection (11) I219-LM"),
+		newPCIDevice("node1", "0000:00:01.0", "", "PCI bridge: Intel Corporation 6th-10th Gen Core Processor PCIe Controller (x16)"),
+		newPCIDevice("node1", "0000:02:08.0", "0000:01:00.0", "PCI bridge: PLX Technology, Inc. PEX 8747"),
+		newPCIDevice("node1", "0000:02:10.0", "0000:01:00.0", "PCI bridge: PLX Technology, Inc. PEX 8747"),
+		newPCIDevice("node1", "0000:01:00.0", "0000:00:01.0", "PCI bridge: PLX Technology, Inc. PEX 8747"),
+		newPCIDevice("node2", "0000:00:01.0", "", "PCI bridge: AMD Starship/Matisse GPP Bridge"),
+	}
+	var out bytes.Buffer
+	if err := Print(&out, pds, "node1"); err != nil {
+		t.Fatal(err)
+	}
+	expected := `0000:00:01.0 PCI bridge: Intel Corporation 6th-10th Gen Core Processor PCIe Controller (x16)
+└── 0000:01:00.0 PCI bridge: PLX Technology, Inc. PEX 8747
+    ├── 0000:02:08.0 PCI bridge: PLX Technology, Inc. PEX 8747
+    │   └── 0000:03:00.0 VGA compatible controller: NVIDIA Corporation TU104GL [Tesla T4]
+    └── 0000:02:10.0 PCI bridge: PLX Technology, Inc. PEX 8747
+0000:00:1f.6 Ethernet controller: Intel Corporation Ethernet Connection (11) I219-LM
+`
+	if out.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	if err := Print(&out, pds, "node3"); err == nil {
+		t.Fatal("expected an error for a node without PCI devices")
+	}
+}
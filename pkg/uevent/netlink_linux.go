@@ -0,0 +1,78 @@
+package uevent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	kernelGroup  = 1
+	receiveBufSz = 1 << 20
+)
+
+// NetlinkSource reads uevents from a NETLINK_KOBJECT_UEVENT socket
+type NetlinkSource struct{}
+
+func NewNetlinkSource() *NetlinkSource {
+	return &NetlinkSource{}
+}
+
+func (s *NetlinkSource) Events(ctx context.Context) (<-chan Event, error) {
+	fd, err := syscall.Socket(
+		syscall.AF_NETLINK,
+		syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK,
+		syscall.NETLINK_KOBJECT_UEVENT,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// A bigger buffer makes it less likely to drop events during a burst, like when VFs are created
+	_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, receiveBufSz)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: kernelGroup,
+	}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// Wrapping the socket in an os.File puts it on the runtime poller, so that closing it unblocks Read
+	file := os.NewFile(uintptr(fd), "uevent")
+
+	events := make(chan Event)
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+	go func() {
+		defer close(events)
+		buf := make([]byte, os.Getpagesize()*4)
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if errors.Is(err, syscall.ENOBUFS) {
+					logrus.Warn("Kernel uevent buffer overrun, some events were lost")
+					continue
+				}
+				logrus.Errorf("Failed to read kernel uevent: %v", err)
+				return
+			}
+			event, err := Parse(buf[:n])
+			if err != nil {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package uevent
+
+import (
+	"context"
+	"errors"
+)
+
+// NetlinkSource is only supported on Linux
+type NetlinkSource struct{}
+
+func NewNetlinkSource() *NetlinkSource {
+	return &NetlinkSource{}
+}
+
+func (s *NetlinkSource) Events(ctx context.Context) (<-chan Event, error) {
+	return nil, errors.New("kernel uevents are only supported on linux")
+}
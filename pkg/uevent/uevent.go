@@ -0,0 +1,70 @@
+// The uevent module listens to the kernel's uevent stream, so that changes to
+// devices and their drivers can be picked up as they happen, instead of polling.
+
+package uevent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+	ActionChange = "change"
+	ActionBind   = "bind"
+	ActionUnbind = "unbind"
+
+	SubsystemPCI = "pci"
+)
+
+// Event is a single kernel uevent
+type Event struct {
+	Action    string
+	DevPath   string
+	Subsystem string
+	Env       map[string]string
+}
+
+// PCIAddress returns the address of the PCI device the event is about
+func (e Event) PCIAddress() string {
+	if addr, ok := e.Env["PCI_SLOT_NAME"]; ok {
+		return addr
+	}
+	return filepath.Base(e.DevPath)
+}
+
+// Source delivers kernel uevents until the context is cancelled, after which
+// the channel is closed.
+type Source interface {
+	Events(ctx context.Context) (<-chan Event, error)
+}
+
+// Parse decodes a kernel uevent message, which looks like
+// "action@devpath\0ACTION=action\0DEVPATH=devpath\0SUBSYSTEM=pci\0..."
+func Parse(msg []byte) (Event, error) {
+	fields := bytes.Split(msg, []byte{0})
+	header := string(fields[0])
+	if !strings.Contains(header, "@") {
+		return Event{}, errors.New("not a kernel uevent: " + header)
+	}
+	event := Event{Env: map[string]string{}}
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(string(field), "=")
+		if !found {
+			continue
+		}
+		event.Env[key] = value
+	}
+	event.Action = event.Env["ACTION"]
+	event.DevPath = event.Env["DEVPATH"]
+	event.Subsystem = event.Env["SUBSYSTEM"]
+	if event.Action == "" || event.DevPath == "" {
+		action, devPath, _ := strings.Cut(header, "@")
+		event.Action, event.DevPath = action, devPath
+	}
+	return event, nil
+}
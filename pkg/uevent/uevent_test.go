@@ -0,0 +1,47 @@
+package uevent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	msg := strings.Join([]string{
+		"bind@/devices/pci0000:00/0000:00:03.0/0000:22:00.0",
+		"ACTION=bind",
+		"DEVPATH=/devices/pci0000:00/0000:00:03.0/0000:22:00.0",
+		"SUBSYSTEM=pci",
+		"DRIVER=vfio-pci",
+		"PCI_SLOT_NAME=0000:22:00.0",
+		"SEQNUM=4242",
+	}, "\x00")
+	event, err := Parse([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Action != ActionBind || event.Subsystem != SubsystemPCI {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.Env["DRIVER"] != "vfio-pci" {
+		t.Fatalf("expected DRIVER=vfio-pci, got %s", event.Env["DRIVER"])
+	}
+	if event.PCIAddress() != "0000:22:00.0" {
+		t.Fatalf("expected 0000:22:00.0, got %s", event.PCIAddress())
+	}
+}
+
+func TestParseWithoutEnv(t *testing.T) {
+	event, err := Parse([]byte("remove@/devices/pci0000:00/0000:00:03.0/0000:22:00.1\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Action != ActionRemove || event.PCIAddress() != "0000:22:00.1" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestParseRejectsUdevMessages(t *testing.T) {
+	if _, err := Parse([]byte("libudev\x00\xfe\xed\xca\xfe")); err == nil {
+		t.Fatal("expected an error for a udev message")
+	}
+}
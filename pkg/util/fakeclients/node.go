@@ -0,0 +1,76 @@
+package fakeclients
+
+import (
+	"context"
+
+	ctlcorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1type "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// NodeClient adapts the fake kubernetes clientset to the wrangler generated NodeClient
+type NodeClient func() corev1type.NodeInterface
+
+func (c NodeClient) Create(n *corev1.Node) (*corev1.Node, error) {
+	return c().Create(context.TODO(), n, metav1.CreateOptions{})
+}
+
+func (c NodeClient) Update(n *corev1.Node) (*corev1.Node, error) {
+	return c().Update(context.TODO(), n, metav1.UpdateOptions{})
+}
+
+func (c NodeClient) UpdateStatus(n *corev1.Node) (*corev1.Node, error) {
+	return c().UpdateStatus(context.TODO(), n, metav1.UpdateOptions{})
+}
+
+func (c NodeClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return c().Delete(context.TODO(), name, *options)
+}
+
+func (c NodeClient) Get(name string, options metav1.GetOptions) (*corev1.Node, error) {
+	return c().Get(context.TODO(), name, options)
+}
+
+func (c NodeClient) List(opts metav1.ListOptions) (*corev1.NodeList, error) {
+	return c().List(context.TODO(), opts)
+}
+
+func (c NodeClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return c().Watch(context.TODO(), opts)
+}
+
+func (c NodeClient) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *corev1.Node, err error) {
+	return c().Patch(context.TODO(), name, pt, data, metav1.PatchOptions{}, subresources...)
+}
+
+// NodeCache adapts the fake kubernetes clientset to the wrangler generated NodeCache, reading
+// straight from the clientset
+type NodeCache func() corev1type.NodeInterface
+
+func (c NodeCache) Get(name string) (*corev1.Node, error) {
+	return c().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (c NodeCache) List(selector labels.Selector) ([]*corev1.Node, error) {
+	list, err := c().List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*corev1.Node, 0, len(list.Items))
+	for i := range list.Items {
+		result = append(result, &list.Items[i])
+	}
+	return result, nil
+}
+
+func (c NodeCache) AddIndexer(indexName string, indexer ctlcorev1.NodeIndexer) {
+	panic("implement me")
+}
+
+func (c NodeCache) GetByIndex(indexName, key string) ([]*corev1.Node, error) {
+	panic("implement me")
+}
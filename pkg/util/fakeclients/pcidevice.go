@@ -0,0 +1,107 @@
+package fakeclients
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	pcidevicesv1beta1 "github.com/harvester/pcidevices/pkg/generated/clientset/versioned/typed/devices.harvesterhci.io/v1beta1"
+	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+// PCIDeviceClient adapts the fake clientset to the wrangler generated PCIDeviceClient
+type PCIDeviceClient func() pcidevicesv1beta1.PCIDeviceInterface
+
+func (c PCIDeviceClient) Create(d *v1beta1.PCIDevice) (*v1beta1.PCIDevice, error) {
+	return c().Create(context.TODO(), d, metav1.CreateOptions{})
+}
+
+func (c PCIDeviceClient) Update(d *v1beta1.PCIDevice) (*v1beta1.PCIDevice, error) {
+	return c().Update(context.TODO(), d, metav1.UpdateOptions{})
+}
+
+// UpdateStatus is the same as Update, since the fake object tracker has no status subresource
+func (c PCIDeviceClient) UpdateStatus(d *v1beta1.PCIDevice) (*v1beta1.PCIDevice, error) {
+	return c().Update(context.TODO(), d, metav1.UpdateOptions{})
+}
+
+func (c PCIDeviceClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return c().Delete(context.TODO(), name, *options)
+}
+
+func (c PCIDeviceClient) Get(name string, options metav1.GetOptions) (*v1beta1.PCIDevice, error) {
+	return c().Get(context.TODO(), name, options)
+}
+
+func (c PCIDeviceClient) List(opts metav1.ListOptions) (*v1beta1.PCIDeviceList, error) {
+	return c().List(context.TODO(), opts)
+}
+
+func (c PCIDeviceClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return c().Watch(context.TODO(), opts)
+}
+
+func (c PCIDeviceClient) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.PCIDevice, err error) {
+	return c().Patch(context.TODO(), name, pt, data, metav1.PatchOptions{}, subresources...)
+}
+
+// PCIDeviceCache adapts the fake clientset to the wrangler generated PCIDeviceCache. It reads straight
+// from the clientset, and evaluates the indexers on every lookup.
+type PCIDeviceCache struct {
+	client   func() pcidevicesv1beta1.PCIDeviceInterface
+	indexers map[string]ctl.PCIDeviceIndexer
+}
+
+func NewPCIDeviceCache(client func() pcidevicesv1beta1.PCIDeviceInterface) *PCIDeviceCache {
+	return &PCIDeviceCache{client: client, indexers: map[string]ctl.PCIDeviceIndexer{}}
+}
+
+func (c *PCIDeviceCache) Get(name string) (*v1beta1.PCIDevice, error) {
+	return c.client().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (c *PCIDeviceCache) List(selector labels.Selector) ([]*v1beta1.PCIDevice, error) {
+	list, err := c.client().List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*v1beta1.PCIDevice, 0, len(list.Items))
+	for i := range list.Items {
+		result = append(result, &list.Items[i])
+	}
+	return result, nil
+}
+
+func (c *PCIDeviceCache) AddIndexer(indexName string, indexer ctl.PCIDeviceIndexer) {
+	c.indexers[indexName] = indexer
+}
+
+func (c *PCIDeviceCache) GetByIndex(indexName, key string) ([]*v1beta1.PCIDevice, error) {
+	indexer, ok := c.indexers[indexName]
+	if !ok {
+		return nil, fmt.Errorf("index %s not found", indexName)
+	}
+	objs, err := c.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var result []*v1beta1.PCIDevice
+	for _, obj := range objs {
+		keys, err := indexer(obj)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if k == key {
+				result = append(result, obj)
+				break
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,107 @@
+package fakeclients
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	pcidevicesv1beta1 "github.com/harvester/pcidevices/pkg/generated/clientset/versioned/typed/devices.harvesterhci.io/v1beta1"
+	ctl "github.com/harvester/pcidevices/pkg/generated/controllers/devices.harvesterhci.io/v1beta1"
+)
+
+// PCIDeviceClaimClient adapts the fake clientset to the wrangler generated PCIDeviceClaimClient
+type PCIDeviceClaimClient func() pcidevicesv1beta1.PCIDeviceClaimInterface
+
+func (c PCIDeviceClaimClient) Create(d *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	return c().Create(context.TODO(), d, metav1.CreateOptions{})
+}
+
+func (c PCIDeviceClaimClient) Update(d *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	return c().Update(context.TODO(), d, metav1.UpdateOptions{})
+}
+
+// UpdateStatus is the same as Update, since the fake object tracker has no status subresource
+func (c PCIDeviceClaimClient) UpdateStatus(d *v1beta1.PCIDeviceClaim) (*v1beta1.PCIDeviceClaim, error) {
+	return c().Update(context.TODO(), d, metav1.UpdateOptions{})
+}
+
+func (c PCIDeviceClaimClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return c().Delete(context.TODO(), name, *options)
+}
+
+func (c PCIDeviceClaimClient) Get(name string, options metav1.GetOptions) (*v1beta1.PCIDeviceClaim, error) {
+	return c().Get(context.TODO(), name, options)
+}
+
+func (c PCIDeviceClaimClient) List(opts metav1.ListOptions) (*v1beta1.PCIDeviceClaimList, error) {
+	return c().List(context.TODO(), opts)
+}
+
+func (c PCIDeviceClaimClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return c().Watch(context.TODO(), opts)
+}
+
+func (c PCIDeviceClaimClient) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.PCIDeviceClaim, err error) {
+	return c().Patch(context.TODO(), name, pt, data, metav1.PatchOptions{}, subresources...)
+}
+
+// PCIDeviceClaimCache adapts the fake clientset to the wrangler generated PCIDeviceClaimCache. It reads straight
+// from the clientset, and evaluates the indexers on every lookup.
+type PCIDeviceClaimCache struct {
+	client   func() pcidevicesv1beta1.PCIDeviceClaimInterface
+	indexers map[string]ctl.PCIDeviceClaimIndexer
+}
+
+func NewPCIDeviceClaimCache(client func() pcidevicesv1beta1.PCIDeviceClaimInterface) *PCIDeviceClaimCache {
+	return &PCIDeviceClaimCache{client: client, indexers: map[string]ctl.PCIDeviceClaimIndexer{}}
+}
+
+func (c *PCIDeviceClaimCache) Get(name string) (*v1beta1.PCIDeviceClaim, error) {
+	return c.client().Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (c *PCIDeviceClaimCache) List(selector labels.Selector) ([]*v1beta1.PCIDeviceClaim, error) {
+	list, err := c.client().List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*v1beta1.PCIDeviceClaim, 0, len(list.Items))
+	for i := range list.Items {
+		result = append(result, &list.Items[i])
+	}
+	return result, nil
+}
+
+func (c *PCIDeviceClaimCache) AddIndexer(indexName string, indexer ctl.PCIDeviceClaimIndexer) {
+	c.indexers[indexName] = indexer
+}
+
+func (c *PCIDeviceClaimCache) GetByIndex(indexName, key string) ([]*v1beta1.PCIDeviceClaim, error) {
+	indexer, ok := c.indexers[indexName]
+	if !ok {
+		return nil, fmt.Errorf("index %s not found", indexName)
+	}
+	objs, err := c.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	var result []*v1beta1.PCIDeviceClaim
+	for _, obj := range objs {
+		keys, err := indexer(obj)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if k == key {
+				result = append(result, obj)
+				break
+			}
+		}
+	}
+	return result, nil
+}
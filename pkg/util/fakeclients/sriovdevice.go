@@ -0,0 +1,48 @@
+package fakeclients
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/harvester/pcidevices/pkg/apis/devices.harvesterhci.io/v1beta1"
+	pcidevicesv1beta1 "github.com/harvester/pcidevices/pkg/generated/clientset/versioned/typed/devices.harvesterhci.io/v1beta1"
+)
+
+// SRIOVDeviceClient adapts the fake clientset to the wrangler generated SRIOVDeviceClient
+type SRIOVDeviceClient func() pcidevicesv1beta1.SRIOVDeviceInterface
+
+func (c SRIOVDeviceClient) Create(d *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error) {
+	return c().Create(context.TODO(), d, metav1.CreateOptions{})
+}
+
+func (c SRIOVDeviceClient) Update(d *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error) {
+	return c().Update(context.TODO(), d, metav1.UpdateOptions{})
+}
+
+// UpdateStatus is the same as Update, since the fake object tracker has no status subresource
+func (c SRIOVDeviceClient) UpdateStatus(d *v1beta1.SRIOVDevice) (*v1beta1.SRIOVDevice, error) {
+	return c().Update(context.TODO(), d, metav1.UpdateOptions{})
+}
+
+func (c SRIOVDeviceClient) Delete(name string, options *metav1.DeleteOptions) error {
+	return c().Delete(context.TODO(), name, *options)
+}
+
+func (c SRIOVDeviceClient) Get(name string, options metav1.GetOptions) (*v1beta1.SRIOVDevice, error) {
+	return c().Get(context.TODO(), name, options)
+}
+
+func (c SRIOVDeviceClient) List(opts metav1.ListOptions) (*v1beta1.SRIOVDeviceList, error) {
+	return c().List(context.TODO(), opts)
+}
+
+func (c SRIOVDeviceClient) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	return c().Watch(context.TODO(), opts)
+}
+
+func (c SRIOVDeviceClient) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.SRIOVDevice, err error) {
+	return c().Patch(context.TODO(), name, pt, data, metav1.PatchOptions{}, subresources...)
+}